@@ -0,0 +1,308 @@
+/*
+alexa-verify.go
+
+Verification of requests sent to the Alexa skill endpoint, as required by Amazon
+for skills hosted outside AWS Lambda.
+- The signing certificate chain must be downloaded from s3.amazonaws.com under /echo.api/,
+  chain up to a trusted root and be issued for echo-api.amazon.com.
+- The request body must carry a valid RSA signature of that certificate.
+- The request timestamp must be within 150 seconds of the current time, against replays.
+- Downloaded certificates are cached per normalised URL until they expire. The cache is
+  capped and concurrent downloads of the same URL are shared, so requests that cannot
+  be verified cannot make the server download without limit.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request headers carrying the signature
+const (
+	alexaCertChainHeader  string = "SignatureCertChainUrl"
+	alexaSignature256     string = "Signature-256"
+	alexaSignatureHeader  string = "Signature"
+	alexaCertHost         string = "s3.amazonaws.com"
+	alexaCertPathPrefix   string = "/echo.api/"
+	alexaCertSubjectName  string = "echo-api.amazon.com"
+	alexaTimestampWindow         = 150 * time.Second
+	alexaCertFetchTimeout        = 10 * time.Second
+	alexaCertCacheSize           = 8
+)
+
+// Signing certificates by normalised URL
+var alexaCerts = struct {
+	lock  sync.Mutex
+	certs map[string]*x509.Certificate
+}{certs: make(map[string]*x509.Certificate)}
+
+// Deduplicates concurrent downloads per normalised URL. Failures are not kept, their
+// URLs are chosen by the sender.
+var alexaCertFetches = fetchGroup{calls: make(map[string]*fetchCall)}
+
+var alexaCertClient = &http.Client{Timeout: alexaCertFetchTimeout}
+
+/*
+verifyAlexaRequest: Verifies the signature of an Alexa request body against the
+signing certificate named in its headers.
+*/
+func verifyAlexaRequest(header http.Header, body []byte, now time.Time) error {
+	certUrl := header.Get(alexaCertChainHeader)
+	if certUrl == "" {
+		return errors.New("missing " + alexaCertChainHeader + " header")
+	}
+
+	cert, err := alexaSigningCert(certUrl, now)
+	if err != nil {
+		return err
+	}
+
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate has no RSA key")
+	}
+
+	// SHA-256 signatures replace the SHA-1 ones, which are still sent along
+	hash, signature := crypto.SHA256, header.Get(alexaSignature256)
+	if signature == "" {
+		hash, signature = crypto.SHA1, header.Get(alexaSignatureHeader)
+	}
+
+	if signature == "" {
+		return errors.New("missing signature header")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA256 {
+		sum := sha256.Sum256(body)
+		digest = sum[:]
+	} else {
+		sum := sha1.Sum(body)
+		digest = sum[:]
+	}
+
+	if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, decoded); err != nil {
+		return fmt.Errorf("signature does not match: %v", err)
+	}
+
+	return nil
+}
+
+/*
+verifyAlexaTimestamp: Checks that the request was sent within the allowed window,
+either way, of the current time.
+*/
+func verifyAlexaTimestamp(timestamp string, now time.Time) error {
+	sent, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %v", timestamp, err)
+	}
+
+	if age := now.Sub(sent); age > alexaTimestampWindow || age < -alexaTimestampWindow {
+		return fmt.Errorf("timestamp %s outside of %s", timestamp, alexaTimestampWindow)
+	}
+
+	return nil
+}
+
+/*
+normaliseAlexaCertUrl: Checks that the certificate chain URL points to the Alexa
+certificates on S3, e.g. https://s3.amazonaws.com/echo.api/echo-api-cert.pem, and returns
+it in normal form. Scheme and host are case insensitive, the path is cleaned, and port,
+query and fragment are dropped.
+*/
+func normaliseAlexaCertUrl(certUrl string) (string, error) {
+	parsed, err := neturl.Parse(certUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate URL %q: %v", certUrl, err)
+	}
+
+	if !strings.EqualFold(parsed.Scheme, "https") {
+		return "", fmt.Errorf("certificate URL %q is not https", certUrl)
+	}
+
+	if !strings.EqualFold(parsed.Hostname(), alexaCertHost) {
+		return "", fmt.Errorf("certificate URL %q is not on %s", certUrl, alexaCertHost)
+	}
+
+	if port := parsed.Port(); port != "" && port != "443" {
+		return "", fmt.Errorf("certificate URL %q is not on port 443", certUrl)
+	}
+
+	cleaned := path.Clean(parsed.Path)
+	if !strings.HasPrefix(cleaned, alexaCertPathPrefix) {
+		return "", fmt.Errorf("certificate URL %q is not under %s", certUrl, alexaCertPathPrefix)
+	}
+
+	return "https://" + alexaCertHost + cleaned, nil
+}
+
+/*
+alexaSigningCert: Returns the verified signing certificate of the chain at the URL,
+downloading the chain unless a valid one is cached. Requests for a chain that is
+being downloaded wait for that download.
+*/
+func alexaSigningCert(certUrl string, now time.Time) (*x509.Certificate, error) {
+	key, err := normaliseAlexaCertUrl(certUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert, ok := cachedAlexaCert(key, now); ok {
+		return cert, nil
+	}
+
+	var fetched *x509.Certificate
+	var fetchErr error
+	ok := alexaCertFetches.do(key, func() bool {
+		if fetched, fetchErr = downloadAlexaCert(key, now); fetchErr != nil {
+			return false
+		}
+
+		cacheAlexaCert(key, fetched, now)
+		return true
+	})
+
+	if !ok {
+		if fetchErr == nil {
+			// The download of another request failed
+			fetchErr = errors.New("downloading certificate chain failed")
+		}
+		return nil, fetchErr
+	}
+
+	if fetched != nil {
+		return fetched, nil
+	}
+
+	// Downloaded by another request
+	if cert, ok := cachedAlexaCert(key, now); ok {
+		return cert, nil
+	}
+
+	return nil, errors.New("signing certificate not available")
+}
+
+/*
+cachedAlexaCert: Helper function - Returns the cached certificate of the URL, unless
+it has expired.
+*/
+func cachedAlexaCert(key string, now time.Time) (*x509.Certificate, bool) {
+	alexaCerts.lock.Lock()
+	defer alexaCerts.lock.Unlock()
+
+	cert, ok := alexaCerts.certs[key]
+	if !ok || !now.Before(cert.NotAfter) {
+		return nil, false
+	}
+
+	return cert, true
+}
+
+/*
+cacheAlexaCert: Helper function - Caches the certificate of the URL. Expired
+certificates make room first, any other one if the cache is still full.
+*/
+func cacheAlexaCert(key string, cert *x509.Certificate, now time.Time) {
+	alexaCerts.lock.Lock()
+	defer alexaCerts.lock.Unlock()
+
+	for cached, c := range alexaCerts.certs {
+		if !now.Before(c.NotAfter) {
+			delete(alexaCerts.certs, cached)
+		}
+	}
+
+	for cached := range alexaCerts.certs {
+		if len(alexaCerts.certs) < alexaCertCacheSize {
+			break
+		}
+		delete(alexaCerts.certs, cached)
+	}
+
+	alexaCerts.certs[key] = cert
+}
+
+/*
+downloadAlexaCert: Downloads the certificate chain at the URL and returns its verified
+signing certificate.
+*/
+func downloadAlexaCert(certUrl string, now time.Time) (*x509.Certificate, error) {
+	resp, err := alexaCertClient.Get(certUrl)
+	if err != nil {
+		return nil, fmt.Errorf("downloading certificate chain failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading certificate chain failed: %s", resp.Status)
+	}
+
+	chain, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate chain failed: %v", err)
+	}
+
+	return verifyAlexaCertChain(chain, now)
+}
+
+/*
+verifyAlexaCertChain: Parses a PEM certificate chain, signing certificate first, and
+verifies it against the system roots for echo-api.amazon.com.
+*/
+func verifyAlexaCertChain(chain []byte, now time.Time) (*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for block, rest := pem.Decode(chain); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in chain: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates in chain")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// Nil roots use the system roots
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       alexaCertSubjectName,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	}); err != nil {
+		return nil, fmt.Errorf("certificate chain not valid: %v", err)
+	}
+
+	return certs[0], nil
+}
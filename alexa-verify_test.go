@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testAlexaCertUrl = "https://s3.amazonaws.com/echo.api/echo-api-cert-test.pem"
+
+// newTestAlexaCert creates a self-signed certificate for echo-api.amazon.com valid
+// until notAfter.
+func newTestAlexaCert(t *testing.T, notAfter time.Time) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: alexaCertSubjectName},
+		DNSNames:     []string{alexaCertSubjectName},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestNormaliseAlexaCertUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		certUrl string
+		want    string
+	}{
+		{"canonical", "https://s3.amazonaws.com/echo.api/echo-api-cert.pem", "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"},
+		{"scheme and host in upper case", "HTTPS://s3.AmazonAWS.com/echo.api/echo-api-cert.pem", "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"},
+		{"explicit port", "https://s3.amazonaws.com:443/echo.api/echo-api-cert.pem", "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"},
+		{"dot segments", "https://s3.amazonaws.com/echo.api/../echo.api/echo-api-cert.pem", "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"},
+		{"query and fragment", "https://s3.amazonaws.com/echo.api/echo-api-cert.pem?v=1#x", "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"},
+		{"not https", "http://s3.amazonaws.com/echo.api/echo-api-cert.pem", ""},
+		{"other host", "https://notamazon.com/echo.api/echo-api-cert.pem", ""},
+		{"other port", "https://s3.amazonaws.com:563/echo.api/echo-api-cert.pem", ""},
+		{"path in upper case", "https://s3.amazonaws.com/EcHo.aPi/echo-api-cert.pem", ""},
+		{"path escaping the prefix", "https://s3.amazonaws.com/echo.api/../invalid.pem", ""},
+		{"not a URL", "https://s3.amazonaws.com/%zz", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normaliseAlexaCertUrl(tt.certUrl)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("normaliseAlexaCertUrl(%q) = %q, want an error", tt.certUrl, got)
+				}
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Errorf("normaliseAlexaCertUrl(%q) = %q, %v, want %q", tt.certUrl, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAlexaTimestamp(t *testing.T) {
+	now := time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		ok        bool
+	}{
+		{"now", "2020-03-02T12:00:00Z", true},
+		{"within window", "2020-03-02T11:57:31Z", true},
+		{"too old", "2020-03-02T11:57:29Z", false},
+		{"within window in the future", "2020-03-02T12:02:29Z", true},
+		{"too far in the future", "2020-03-02T12:02:31Z", false},
+		{"other time zone", "2020-03-02T14:00:00+02:00", true},
+		{"invalid", "yesterday", false},
+		{"missing", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyAlexaTimestamp(tt.timestamp, now); (err == nil) != tt.ok {
+				t.Errorf("verifyAlexaTimestamp(%q) = %v, want ok %v", tt.timestamp, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyAlexaRequest(t *testing.T) {
+	now := time.Now()
+	cert, key := newTestAlexaCert(t, now.Add(24*time.Hour))
+	_, otherKey := newTestAlexaCert(t, now.Add(24*time.Hour))
+
+	// The chain is cached, so nothing is downloaded
+	cacheAlexaCert(testAlexaCertUrl, cert, now)
+	defer func() {
+		alexaCerts.lock.Lock()
+		delete(alexaCerts.certs, testAlexaCertUrl)
+		alexaCerts.lock.Unlock()
+	}()
+
+	body := []byte(`{"version":"1.0","request":{"type":"LaunchRequest"}}`)
+
+	sign := func(key *rsa.PrivateKey, hash crypto.Hash, body []byte) string {
+		var digest []byte
+		if hash == crypto.SHA256 {
+			sum := sha256.Sum256(body)
+			digest = sum[:]
+		} else {
+			sum := sha1.Sum(body)
+			digest = sum[:]
+		}
+
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    []byte
+		ok      bool
+	}{
+		{"SHA-256 signature", map[string]string{
+			alexaCertChainHeader: testAlexaCertUrl,
+			alexaSignature256:    sign(key, crypto.SHA256, body),
+		}, body, true},
+		{"SHA-1 signature", map[string]string{
+			alexaCertChainHeader: testAlexaCertUrl,
+			alexaSignatureHeader: sign(key, crypto.SHA1, body),
+		}, body, true},
+		{"certificate URL in other form", map[string]string{
+			alexaCertChainHeader: "HTTPS://s3.amazonaws.com:443/echo.api/./echo-api-cert-test.pem?x=1",
+			alexaSignature256:    sign(key, crypto.SHA256, body),
+		}, body, true},
+		{"body modified", map[string]string{
+			alexaCertChainHeader: testAlexaCertUrl,
+			alexaSignature256:    sign(key, crypto.SHA256, body),
+		}, append([]byte(" "), body...), false},
+		{"signed by other key", map[string]string{
+			alexaCertChainHeader: testAlexaCertUrl,
+			alexaSignature256:    sign(otherKey, crypto.SHA256, body),
+		}, body, false},
+		{"signature not base64", map[string]string{
+			alexaCertChainHeader: testAlexaCertUrl,
+			alexaSignature256:    "not base64!",
+		}, body, false},
+		{"missing signature", map[string]string{
+			alexaCertChainHeader: testAlexaCertUrl,
+		}, body, false},
+		{"missing certificate URL", map[string]string{
+			alexaSignature256: sign(key, crypto.SHA256, body),
+		}, body, false},
+		{"certificate URL elsewhere", map[string]string{
+			alexaCertChainHeader: "https://example.com/echo.api/echo-api-cert-test.pem",
+			alexaSignature256:    sign(key, crypto.SHA256, body),
+		}, body, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for name, value := range tt.headers {
+				header.Set(name, value)
+			}
+
+			if err := verifyAlexaRequest(header, tt.body, now); (err == nil) != tt.ok {
+				t.Errorf("verifyAlexaRequest() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCacheAlexaCertIsCapped(t *testing.T) {
+	now := time.Now()
+	valid, _ := newTestAlexaCert(t, now.Add(time.Hour))
+	expired, _ := newTestAlexaCert(t, now.Add(-time.Hour))
+
+	alexaCerts.lock.Lock()
+	saved := alexaCerts.certs
+	alexaCerts.certs = map[string]*x509.Certificate{"https://s3.amazonaws.com/echo.api/expired.pem": expired}
+	alexaCerts.lock.Unlock()
+	defer func() {
+		alexaCerts.lock.Lock()
+		alexaCerts.certs = saved
+		alexaCerts.lock.Unlock()
+	}()
+
+	if _, ok := cachedAlexaCert("https://s3.amazonaws.com/echo.api/expired.pem", now); ok {
+		t.Error("expired certificate returned from cache")
+	}
+
+	for i := 0; i < 3*alexaCertCacheSize; i++ {
+		key := fmt.Sprintf("https://s3.amazonaws.com/echo.api/cert-%d.pem", i)
+		cacheAlexaCert(key, valid, now)
+
+		if _, ok := cachedAlexaCert(key, now); !ok {
+			t.Fatalf("certificate %d not cached", i)
+		}
+		if size := len(alexaCerts.certs); size > alexaCertCacheSize {
+			t.Fatalf("cache holds %d certificates, want at most %d", size, alexaCertCacheSize)
+		}
+	}
+
+	if _, ok := alexaCerts.certs["https://s3.amazonaws.com/echo.api/expired.pem"]; ok {
+		t.Error("expired certificate kept in cache")
+	}
+}
+
+func TestVerifyAlexaCertChain(t *testing.T) {
+	now := time.Now()
+	cert, _ := newTestAlexaCert(t, now.Add(time.Hour))
+	selfSigned := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	tests := []struct {
+		name  string
+		chain []byte
+	}{
+		{"empty", nil},
+		{"not PEM", []byte("not a certificate")},
+		{"not a certificate", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})},
+		{"self-signed, not chaining to a trusted root", selfSigned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifyAlexaCertChain(tt.chain, now); err == nil {
+				t.Error("verifyAlexaCertChain() accepted the chain")
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+/*
+alexa.go
+
+Endpoint for the Amazon Alexa skill.
+- Requests are verified by signature, timestamp and skill id before anything else.
+- Alexa intents are mapped onto the registered intents, slots onto their parameters,
+  so queries are answered by the same logic as the Dialogflow webhooks.
+- Answers are spoken as SSML and shown as a simple card in the Alexa app.
+- The last query is kept in the "route-followup" session attribute for follow-up questions.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version of the Alexa response format
+const alexaVersion string = "1.0"
+
+// Alexa request types
+const (
+	alexaLaunchRequest       string = "LaunchRequest"
+	alexaIntentRequest       string = "IntentRequest"
+	alexaSessionEndedRequest string = "SessionEndedRequest"
+)
+
+// Alexa intent names cannot contain dashes, so they are mapped onto the registered intents
+var alexaIntents = map[string]string{
+	"DestinationOnlyIntent": DESTONLY,
+	"BusDestinationIntent":  BUSDEST,
+	"NextAfterIntent":       NEXTAFTER,
+	"ChangeRouteIntent":     CHANGEROUTE,
+	"AMAZON.YesIntent":      CONFIRM,
+}
+
+// Alexa slots filling the parameters of the registered intents
+var alexaSlots = map[string]string{
+	"place-attraction": "destination",
+	"route":            "route",
+}
+
+// SSML that Alexa does not support: languages other than its own and times of day.
+// Alexa reads times in say-as as durations, "15:04" on its own as a time of day.
+var (
+	alexaLangTags = regexp.MustCompile(`</?lang[^>]*>`)
+	alexaTimeTags = regexp.MustCompile(`<say-as interpret-as="time"[^>]*>([^<]*)</say-as>`)
+)
+
+// Largest Alexa request body read, requests are read before their signature is verified
+const alexaMaxBodySize int64 = 128 * 1024
+
+// Skill id requests must be sent for, from the config file
+var alexaSkillId string
+
+/*
+applicationId: Returns the id of the skill the request was sent for.
+*/
+func (alexaReq alexaRequestEnvelope) applicationId() string {
+	if alexaReq.Session != nil && alexaReq.Session.Application.ApplicationId != "" {
+		return alexaReq.Session.Application.ApplicationId
+	}
+
+	if alexaReq.Context != nil {
+		return alexaReq.Context.System.Application.ApplicationId
+	}
+
+	return ""
+}
+
+/*
+slotParam: Returns the value of the slot filling the given intent parameter.
+Slots the user did not fill are missing.
+*/
+func (alexaReq alexaRequestEnvelope) slotParam(name string) (interface{}, bool) {
+	if alexaReq.Request.Intent == nil {
+		return nil, false
+	}
+
+	slot, ok := alexaReq.Request.Intent.Slots[alexaSlots[name]]
+	if !ok || slot.Value == "" {
+		return nil, false
+	}
+
+	return slot.Value, true
+}
+
+/*
+sessionAttributes: Returns the session attributes of the request, nil without a session.
+*/
+func (alexaReq alexaRequestEnvelope) sessionAttributes() map[string]interface{} {
+	if alexaReq.Session == nil {
+		return nil
+	}
+
+	return alexaReq.Session.Attributes
+}
+
+/*
+previousQuery: Returns the query kept in the follow-up session attribute, an empty
+query if there is none.
+*/
+func (alexaReq alexaRequestEnvelope) previousQuery() routeQuery {
+	params, _ := alexaReq.sessionAttributes()[FOLLOWUPCONTEXT].(map[string]interface{})
+
+	return queryFromParameters(params)
+}
+
+/*
+alexaSsml: Helper function - Drops the SSML markup Alexa does not support.
+*/
+func alexaSsml(ssml string) string {
+	ssml = alexaLangTags.ReplaceAllString(ssml, "")
+
+	return alexaTimeTags.ReplaceAllString(ssml, "$1")
+}
+
+/*
+alexaAnswerResponse: Formats the answer into Alexa response format. The session is kept
+open for follow-up questions and retries. Departures are also shown on a card.
+*/
+func alexaAnswerResponse(answer routeAnswer, attributes map[string]interface{}) alexaResponseEnvelope {
+	listen := false
+	speech := joinSpeech(answer.speech, " ")
+
+	alexaResp := alexaResponseEnvelope{
+		Version:           alexaVersion,
+		SessionAttributes: attributes,
+		Response: alexaResponse{
+			OutputSpeech:     &alexaOutputSpeech{Type: "SSML", Ssml: alexaSsml(speech.speak())},
+			ShouldEndSession: &listen,
+		},
+	}
+
+	if answer.status == answerOK {
+		var lines []string
+		for _, st := range answer.speech {
+			lines = append(lines, st.text)
+		}
+
+		alexaResp.Response.Card = &alexaCard{
+			Type:    "Simple",
+			Title:   message(answer.language, msgUpcomingDepartures),
+			Content: strings.Join(lines, "\n"),
+		}
+	}
+
+	// Attributes not returned are dropped by Alexa, earlier follow-ups are kept
+	if answer.followup.destination != "" {
+		if alexaResp.SessionAttributes == nil {
+			alexaResp.SessionAttributes = make(map[string]interface{})
+		}
+		alexaResp.SessionAttributes[FOLLOWUPCONTEXT] = answer.followup.parameters()
+	}
+
+	return alexaResp
+}
+
+/*
+alexaEndResponse: Helper function - Ends the session without saying anything.
+*/
+func alexaEndResponse() alexaResponseEnvelope {
+	end := true
+
+	return alexaResponseEnvelope{
+		Version:  alexaVersion,
+		Response: alexaResponse{ShouldEndSession: &end},
+	}
+}
+
+/*
+GetAlexaHandler: Webserver handler for the Alexa skill. Verifies the request, dispatches
+its intent like GetRouteHandler and formats the answer into Alexa response format.
+Requests that cannot be verified are rejected with 400 as Amazon requires, bodies larger
+than alexaMaxBodySize with 413.
+*/
+func GetAlexaHandler(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, alexaMaxBodySize))
+	if err != nil {
+		log.Error("Error reading Alexa request - ", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+
+	if err := verifyAlexaRequest(r.Header, body, now); err != nil {
+		log.Error("Alexa request signature not verified - ", err)
+		respondWithError(w, http.StatusBadRequest)
+		return
+	}
+
+	var alexaReq alexaRequestEnvelope
+	if err := json.Unmarshal(body, &alexaReq); err != nil {
+		log.Error("Error decoding json body in Alexa request - ", err)
+		respondWithError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := verifyAlexaTimestamp(alexaReq.Request.Timestamp, now); err != nil {
+		log.Error("Alexa request timestamp not verified - ", err)
+		respondWithError(w, http.StatusBadRequest)
+		return
+	}
+
+	if alexaReq.applicationId() != alexaSkillId {
+		log.Error("Alexa request for unknown skill - ", alexaReq.applicationId())
+		respondWithError(w, http.StatusBadRequest)
+		return
+	}
+
+	lang := replyLanguage(alexaReq.Request.Locale)
+	log.Info("Alexa Req of type - ", alexaReq.Request.Type)
+
+	var answer routeAnswer
+
+	switch alexaReq.Request.Type {
+	case alexaLaunchRequest:
+		answer = promptAnswer(lang, message(lang, msgWelcomeText), message(lang, msgWelcome))
+	case alexaIntentRequest:
+		if alexaReq.Request.Intent == nil {
+			log.Error("Alexa intent request without intent")
+			respondWithError(w, http.StatusBadRequest)
+			return
+		}
+
+		intent := alexaReq.Request.Intent.Name
+		log.Info("Alexa intent - ", intent)
+
+		switch intent {
+		case "AMAZON.StopIntent", "AMAZON.CancelIntent":
+			respondWithJSON(w, http.StatusOK, alexaEndResponse())
+			return
+		case "AMAZON.HelpIntent":
+			answer = promptAnswer(lang, message(lang, msgWelcomeText), message(lang, msgWelcome))
+		default:
+			if registered, ok := alexaIntents[intent]; ok {
+				intent = registered
+			}
+			answer = dispatchIntent(intent, alexaReq.slotParam, lang, alexaReq.previousQuery())
+		}
+	case alexaSessionEndedRequest:
+		// No speech is allowed in response to a session that has ended
+		log.Info("Alexa session ended - ", alexaReq.Request.Reason)
+		respondWithJSON(w, http.StatusOK, alexaResponseEnvelope{Version: alexaVersion})
+		return
+	default:
+		log.Error("Unsupported Alexa request type - ", alexaReq.Request.Type)
+		answer = fallbackAnswer(lang)
+	}
+
+	alexaResp := alexaAnswerResponse(answer, alexaReq.sessionAttributes())
+
+	if answer.status == answerError {
+		log.Error("Alexa ERROR RESP - ", alexaResp)
+	} else {
+		log.Info("Alexa RESP - ", alexaResp)
+	}
+	respondWithJSON(w, http.StatusOK, alexaResp)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetAlexaHandlerBodySize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want int
+	}{
+		{"unsigned request", 1024, http.StatusBadRequest},
+		{"largest body", alexaMaxBodySize, http.StatusBadRequest},
+		{"body too large", alexaMaxBodySize + 1, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte(" "), int(tt.size))
+			w := httptest.NewRecorder()
+
+			GetAlexaHandler(w, httptest.NewRequest("POST", "/alexa", bytes.NewReader(body)))
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
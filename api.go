@@ -0,0 +1,193 @@
+/*
+api.go
+
+Versioned REST API for dashboards and scripts, answering from the same departure
+store the voice assistants use.
+- GET /api/v1/departures?stop=HSL:2143218&route=215&dest=Sello&limit=5 lists upcoming
+  departures, earliest first. All query parameters are optional.
+- GET /api/v1/stops lists the configured stops.
+- GET /api/v1/destinations lists the configured call signs.
+- Times are absolute RFC 3339 timestamps in Helsinki time. Errors are returned as {"error": "..."}.
+*/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Number of departures returned by default and at most
+const (
+	apiDefaultLimit = 10
+	apiMaxLimit     = 100
+)
+
+/*
+respondWithAPIError: Helper function - For REST API responses with an error message.
+*/
+func respondWithAPIError(w http.ResponseWriter, code int, msg string) {
+	log.Error("API ERROR RESP - ", code, " ", msg)
+	respondWithJSON(w, code, apiError{Error: msg})
+}
+
+/*
+apiStopFrom: Helper function - REST API form of a stop.
+*/
+func apiStopFrom(stop stopStruct) apiStop {
+	return apiStop{
+		GtfsId:    stop.gtfsId,
+		Name:      stop.name,
+		NameSv:    stop.nameSv,
+		Code:      stop.code,
+		Latitude:  stop.latitude,
+		Longitude: stop.longitude,
+	}
+}
+
+/*
+apiDepartureFrom: Helper function - REST API form of a departure from the stop.
+*/
+func apiDepartureFrom(stop stopStruct, arrDep routeArrDepDetails) apiDeparture {
+	dep := apiDeparture{
+		Stop:               apiStopFrom(stop),
+		Route:              arrDep.route,
+		RouteGtfsId:        arrDep.routeGtfsId,
+		Mode:               arrDep.mode,
+		Headsign:           arrDep.headSign,
+		ScheduledDeparture: arrDep.scheduledDepartureTime.In(hslLocation),
+		Departure:          arrDep.departureTime().In(hslLocation),
+		DelaySeconds:       int(arrDep.departureDelay),
+		Realtime:           arrDep.realtime,
+	}
+
+	if arrDep.realtime {
+		realtime := arrDep.realtimeDepartureTime.In(hslLocation)
+		dep.RealtimeDeparture = &realtime
+	}
+
+	return dep
+}
+
+/*
+GetDeparturesAPIHandler: REST API handler for upcoming departures. Filters by stop gtfsId,
+exact route and destination call sign. Destinations are resolved like spoken ones, but
+only confident matches are accepted.
+*/
+func GetDeparturesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	stop := params.Get("stop")
+	route := params.Get("route")
+	destination := params.Get("dest")
+
+	limit := apiDefaultLimit
+	if value := params.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > apiMaxLimit {
+			respondWithAPIError(w, http.StatusBadRequest, "limit must be a number from 1 to "+strconv.Itoa(apiMaxLimit))
+			return
+		}
+	}
+
+	if stop != "" {
+		known := false
+		for _, gtfsId := range departureData.stopIds() {
+			known = known || strings.EqualFold(gtfsId, stop)
+		}
+
+		if !known {
+			respondWithAPIError(w, http.StatusNotFound, "stop "+stop+" is not configured")
+			return
+		}
+	}
+
+	var dest destinationSpec
+	if destination != "" {
+		resolved := resolveDestination(destination)
+		if resolved.confidence < destinationConfident {
+			respondWithAPIError(w, http.StatusNotFound, "destination "+destination+" is not configured")
+			return
+		}
+		dest = resolved.destination
+	}
+
+	log.Info("API Req for departures from stop - ", stop, " route - ", route, " to destination - ", destination)
+
+	// Make sure answers are not based on stale departures
+	ensureFresh(cacheTTL)
+
+	now := time.Now()
+	resp := apiDeparturesResponse{GeneratedAt: now.In(hslLocation), Departures: []apiDeparture{}}
+
+	for _, stopDeps := range departureData.departures(route, dest, now.Add(-departureGrace)) {
+		if stop != "" && !strings.EqualFold(stopDeps.stop.gtfsId, stop) {
+			continue
+		}
+
+		for _, arrDep := range stopDeps.departures {
+			resp.Departures = append(resp.Departures, apiDepartureFrom(stopDeps.stop, arrDep))
+		}
+	}
+
+	sort.SliceStable(resp.Departures, func(i, j int) bool {
+		return resp.Departures[i].Departure.Before(resp.Departures[j].Departure)
+	})
+
+	if len(resp.Departures) > limit {
+		resp.Departures = resp.Departures[:limit]
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+/*
+GetStopsAPIHandler: REST API handler for the configured stops. Details are known once
+departures of the stop have been retrieved.
+*/
+func GetStopsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	resp := apiStopsResponse{Stops: []apiStop{}}
+
+	for _, gtfsId := range departureData.stopIds() {
+		snap, ok := departureData.stop(gtfsId)
+		if !ok {
+			resp.Stops = append(resp.Stops, apiStop{GtfsId: gtfsId})
+			continue
+		}
+
+		stop := apiStopFrom(snap.data.stopDetails)
+		stop.GtfsId = gtfsId
+		updated := snap.updated.In(hslLocation)
+		stop.Updated = &updated
+
+		resp.Stops = append(resp.Stops, stop)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+/*
+GetDestinationsAPIHandler: REST API handler for the configured call signs, in
+alphabetical order.
+*/
+func GetDestinationsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	resp := apiDestinationsResponse{Destinations: []apiDestination{}}
+
+	for callSign, dest := range configSigns {
+		resp.Destinations = append(resp.Destinations, apiDestination{
+			CallSign:  callSign,
+			Headsigns: dest.headsigns,
+			Stops:     dest.stops,
+		})
+	}
+
+	sort.Slice(resp.Destinations, func(i, j int) bool {
+		return resp.Destinations[i].CallSign < resp.Destinations[j].CallSign
+	})
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
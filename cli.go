@@ -0,0 +1,218 @@
+/*
+cli.go
+
+Command line client mode of the binary, for debugging config and data without curl
+and client certificates.
+- serve: starts the webserver. Also the default when no subcommand is given.
+- next: prints the answer the Assistant would speak, e.g.
+  ga-hsl-hrt next --dest Sello --route 215
+- stops search: looks up HSL stops by name or code, e.g.
+  ga-hsl-hrt stops search Jupperinympyrä
+- stops resolve: resolves a stop code or name into its gtfsId and keeps it in the stop
+  lookup file, e.g. ga-hsl-hrt stops resolve E1439
+Output goes to stdout, logging still goes to the configured log file.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes of the subcommands
+const (
+	exitOK         = 0
+	exitFailed     = 1
+	exitUsageError = 2
+)
+
+// Usage of the binary
+const cliUsage string = `Usage:
+  ga-hsl-hrt [serve]
+        Start the webserver.
+  ga-hsl-hrt next --dest <call sign> [--route <line>] [--after <HH:MM>] [--lang <en|fi|sv>] [--ssml]
+        Print the answer to the next departures, as the Assistant would speak it.
+  ga-hsl-hrt stops search <name or code>
+        Search HSL stops, e.g. to find the gtfsId for "stopGtfsIds".
+  ga-hsl-hrt stops resolve <code, name or name/platform>
+        Resolve a stop into its gtfsId and keep it in the stop lookup file.
+`
+
+/*
+runCommand: Runs the subcommand in args and returns the exit code.
+*/
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		serve()
+		return exitOK
+	}
+
+	switch args[0] {
+	case "serve":
+		serve()
+		return exitOK
+	case "next":
+		return runNext(args[1:], os.Stdout)
+	case "stops":
+		return runStops(args[1:], os.Stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, cliUsage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n%s", args[0], cliUsage)
+		return exitUsageError
+	}
+}
+
+/*
+runNext: Answers a departure query from the command line like the Assistant would,
+after retrieving departures of all configured and nearby stops.
+*/
+func runNext(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("next", flag.ContinueOnError)
+	dest := flags.String("dest", "", "destination call sign, e.g. Sello")
+	route := flags.String("route", "", "line, e.g. 215 or 231N")
+	after := flags.String("after", "", "only departures after this time of day, e.g. 15:04")
+	lang := flags.String("lang", langEnglish, "reply language: en, fi or sv")
+	ssml := flags.Bool("ssml", false, "print SSML instead of plain text")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsageError
+	}
+
+	if *dest == "" {
+		fmt.Fprintf(os.Stderr, "--dest is required\n%s", cliUsage)
+		return exitUsageError
+	}
+
+	var afterTime time.Time
+	if *after != "" {
+		minutes, err := parseClock(*after)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--after: %v\n%s", err, cliUsage)
+			return exitUsageError
+		}
+
+		year, month, day := time.Now().In(hslLocation).Date()
+		afterTime = time.Date(year, month, day, minutes/60, minutes%60, 0, 0, hslLocation)
+	}
+
+	// Stops are resolved for this answer only, "stops resolve" keeps them
+	getConfig()
+	resolveStopConfig(false)
+	departureData = newDepartureStore(configStopGtfsIds)
+	if !homeConfigured || !refreshNearbyStops() {
+		refreshRouteInfo()
+	}
+
+	query := routeQuery{
+		request:     DESTONLY,
+		destination: *dest,
+		language:    replyLanguage(*lang),
+		after:       afterTime,
+	}
+	if *route != "" {
+		query.request = BUSDEST
+		query.route = *route
+	}
+
+	answer := answerRouteQuery(query)
+
+	for _, st := range answer.speech {
+		if *ssml {
+			fmt.Fprintln(out, st.speak())
+		} else {
+			fmt.Fprintln(out, st.text)
+		}
+	}
+
+	if answer.status == answerError {
+		return exitFailed
+	}
+
+	return exitOK
+}
+
+/*
+runStops: Runs the stops subcommands, search and resolve. They need no config file.
+*/
+func runStops(args []string, out io.Writer) int {
+	if len(args) < 2 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return exitUsageError
+	}
+
+	name := strings.Join(args[1:], " ")
+
+	switch args[0] {
+	case "search":
+		return runStopsSearch(name, out)
+	case "resolve":
+		return runStopsResolve(name, out)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown stops command %q\n%s", args[0], cliUsage)
+		return exitUsageError
+	}
+}
+
+/*
+runStopsSearch: Lists the HSL stops matching a name or code.
+*/
+func runStopsSearch(name string, out io.Writer) int {
+	stops, err := searchStops(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Stop search failed:", err)
+		return exitFailed
+	}
+
+	if len(stops) == 0 {
+		fmt.Fprintf(os.Stderr, "No stops found for %q\n", name)
+		return exitFailed
+	}
+
+	for _, stop := range stops {
+		fmt.Fprintf(out, "%-14s %-7s %s (%.5f, %.5f)\n", stop.gtfsId, stop.code, stop.name, stop.latitude, stop.longitude)
+	}
+
+	return exitOK
+}
+
+/*
+runStopsResolve: Resolves a stop code or name into its gtfsId and keeps it in the stop
+lookup file, where the webserver finds it.
+*/
+func runStopsResolve(ref string, out io.Writer) int {
+	if isGtfsId(ref) {
+		fmt.Fprintf(os.Stderr, "%s already is a gtfsId\n", ref)
+		return exitUsageError
+	}
+
+	lookup, err := loadStopLookup(STOPLOOKUPFILE)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Stop lookup could not be read:", err)
+		return exitFailed
+	}
+
+	entry, cached, err := resolveStopRef(ref, lookup)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Stop could not be resolved:", err)
+		return exitFailed
+	}
+
+	if !cached {
+		lookup[stopLookupKey(ref)] = entry
+		if err := saveStopLookup(STOPLOOKUPFILE, lookup); err != nil {
+			fmt.Fprintln(os.Stderr, "Stop lookup could not be saved:", err)
+			return exitFailed
+		}
+	}
+
+	fmt.Fprintf(out, "%-14s %-7s %s (%.5f, %.5f)\n", entry.GtfsId, entry.Code, entry.Name, entry.Latitude, entry.Longitude)
+
+	return exitOK
+}
@@ -0,0 +1,63 @@
+/*
+departure-intents.go
+
+Intents asking for departures towards a destination.
+- Destination-Only: next buses to a destination, e.g. "When is the next bus to Sello?"
+- Bus-Destination: next departures of a bus, e.g. "When is the next 215 to Sello?"
+- Next-After: the departures after the ones told, e.g. "And the one after that?"
+- Change-Route: another bus to the same destination, e.g. "What about 214?"
+- Confirm: a yes to "Did you mean Sello?", answering the query with the suggestion.
+*/
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Parameters of departure intents
+var (
+	destinationParam = intentParam{name: "place-attraction", kind: paramText}
+	routeParam       = intentParam{name: "route", kind: paramRoute}
+)
+
+// An intent answered by a departure query
+type departureIntent struct {
+	intentName string
+	params     []intentParam
+}
+
+func init() {
+	registerIntent(departureIntent{intentName: DESTONLY, params: []intentParam{destinationParam}})
+	registerIntent(departureIntent{intentName: BUSDEST, params: []intentParam{routeParam, destinationParam}})
+	registerIntent(departureIntent{intentName: NEXTAFTER})
+	registerIntent(departureIntent{intentName: CHANGEROUTE, params: []intentParam{routeParam, destinationParam}})
+	registerIntent(departureIntent{intentName: CONFIRM})
+}
+
+func (intent departureIntent) name() string {
+	return intent.intentName
+}
+
+func (intent departureIntent) parameters() []intentParam {
+	return intent.params
+}
+
+/*
+handle: Answers the departure query of the request. Follow-up questions are completed
+from the previous query first.
+*/
+func (intent departureIntent) handle(req intentRequest) routeAnswer {
+	query, ok := followupQuery(routeQuery{
+		request:     intent.intentName,
+		route:       req.params[routeParam.name],
+		destination: req.params[destinationParam.name],
+		language:    req.language,
+	}, req.previous)
+	if !ok {
+		log.Info("No previous query to follow up with request - ", intent.intentName)
+		return promptAnswer(req.language, message(req.language, msgNoFollowupText), message(req.language, msgNoFollowup))
+	}
+
+	return answerRouteQuery(query)
+}
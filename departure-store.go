@@ -0,0 +1,351 @@
+/*
+departure-store.go
+
+Concurrency safe store for the departures retrieved from HSL API.
+- Holds one snapshot per configured bus stop. Snapshots are never modified once stored,
+  a refresh replaces the snapshot of a stop as a whole (copy-on-write).
+- Every update bumps the store version, so readers can tell if data has changed.
+- Webserver handlers query departures through the store instead of iterating raw slices.
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot of the departures of a single stop
+type stopSnapshot struct {
+	data    routeData
+	version uint64
+	updated time.Time
+}
+
+// Departures of a single stop that matched a query
+type stopDepartures struct {
+	stop       stopStruct
+	departures []routeArrDepDetails
+}
+
+// The store itself. Stops are kept in configuration order.
+type departureStore struct {
+	lock    sync.RWMutex
+	version uint64
+	order   []string
+	stops   map[string]*stopSnapshot
+}
+
+/*
+newDepartureStore: Creates an empty store for the given stops. Order of the stops
+is preserved in query results.
+*/
+func newDepartureStore(gtfsIds []string) *departureStore {
+	store := &departureStore{
+		stops: make(map[string]*stopSnapshot),
+	}
+	store.order = append(store.order, gtfsIds...)
+
+	return store
+}
+
+/*
+update: Replaces the snapshot of a stop. The given route data must not be modified
+by the caller afterwards. Data of a stop no longer in the store, e.g. fetched while
+setStops dropped it, is discarded. Returns the store version.
+*/
+func (ds *departureStore) update(gtfsId string, data routeData) uint64 {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	if !ds.known(gtfsId) {
+		return ds.version
+	}
+
+	ds.version++
+	ds.stops[gtfsId] = &stopSnapshot{
+		data:    data,
+		version: ds.version,
+		updated: time.Now(),
+	}
+
+	return ds.version
+}
+
+/*
+known: Checks if the stop is part of the store order. Caller must hold the lock.
+*/
+func (ds *departureStore) known(gtfsId string) bool {
+	for _, id := range ds.order {
+		if id == gtfsId {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+currentVersion: Returns the version of the latest update.
+*/
+func (ds *departureStore) currentVersion() uint64 {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return ds.version
+}
+
+/*
+setStops: Replaces the stops of the store, e.g. when nearby stops have been discovered
+again. Snapshots of stops no longer in the store are dropped, new stops have no data
+until they are updated.
+*/
+func (ds *departureStore) setStops(gtfsIds []string) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	ds.order = append([]string(nil), gtfsIds...)
+	for id := range ds.stops {
+		if !ds.known(id) {
+			delete(ds.stops, id)
+		}
+	}
+
+	ds.version++
+}
+
+/*
+stopIds: Returns the gtfsIds of all stops of the store, in configuration order.
+*/
+func (ds *departureStore) stopIds() (gtfsIds []string) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return append(gtfsIds, ds.order...)
+}
+
+/*
+stop: Returns the snapshot of a single stop, if any data has been stored for it.
+*/
+func (ds *departureStore) stop(gtfsId string) (snapshot stopSnapshot, ok bool) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	snap, ok := ds.stops[gtfsId]
+	if ok {
+		snapshot = *snap
+	}
+
+	return
+}
+
+/*
+missing: Returns the stops for which no data has been stored yet.
+*/
+func (ds *departureStore) missing() (gtfsIds []string) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	for _, id := range ds.order {
+		if _, ok := ds.stops[id]; !ok {
+			gtfsIds = append(gtfsIds, id)
+		}
+	}
+
+	return
+}
+
+/*
+snapshots: Returns the snapshots of all stops that have data, in configuration order.
+*/
+func (ds *departureStore) snapshots() (snapshots []stopSnapshot) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	for _, id := range ds.order {
+		if snap, ok := ds.stops[id]; ok {
+			snapshots = append(snapshots, *snap)
+		}
+	}
+
+	return
+}
+
+/*
+routes: Returns the distinct routes of all stored departures.
+*/
+func (ds *departureStore) routes() (routes []string) {
+	seen := make(map[string]bool)
+
+	for _, snap := range ds.snapshots() {
+		for _, arrDep := range snap.data.arrDepDetails {
+			if arrDep.route != "" && !seen[arrDep.route] {
+				seen[arrDep.route] = true
+				routes = append(routes, arrDep.route)
+			}
+		}
+	}
+
+	return
+}
+
+/*
+departures: Returns departures for route towards the destination leaving at or after
+the given time, grouped per stop. Route must match exactly, an empty route matches every
+route, an empty destination every destination and a zero time every departure. Stops
+without matching departures are left out.
+*/
+func (ds *departureStore) departures(route string, dest destinationSpec, after time.Time) (matches []stopDepartures) {
+	for _, snap := range ds.snapshots() {
+		var found []routeArrDepDetails
+
+		for _, arrDep := range snap.data.arrDepDetails {
+			if route != "" && !strings.EqualFold(arrDep.route, route) {
+				continue
+			}
+
+			if !dest.empty() && !arrDep.goesTo(dest) {
+				continue
+			}
+
+			if !after.IsZero() && arrDep.departureTime().Before(after) {
+				continue
+			}
+
+			found = append(found, arrDep)
+		}
+
+		if len(found) > 0 {
+			matches = append(matches, stopDepartures{
+				stop:       snap.data.stopDetails,
+				departures: found,
+			})
+		}
+	}
+
+	return
+}
+
+/*
+firstDepartures: Keeps the n earliest departures of the matches, and the ones leaving at
+the same time as the last of them, grouped per stop as given. Every other departure
+leaves later than the returned last departure time, so a follow-up can continue
+strictly after it.
+*/
+func firstDepartures(matches []stopDepartures, n int) (first []stopDepartures, last time.Time) {
+	var times []time.Time
+	for _, stopDeps := range matches {
+		for _, arrDep := range stopDeps.departures {
+			times = append(times, arrDep.departureTime())
+		}
+	}
+
+	if len(times) == 0 || n <= 0 {
+		return
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	if n > len(times) {
+		n = len(times)
+	}
+	last = times[n-1]
+
+	for _, stopDeps := range matches {
+		var found []routeArrDepDetails
+		for _, arrDep := range stopDeps.departures {
+			if !arrDep.departureTime().After(last) {
+				found = append(found, arrDep)
+			}
+		}
+
+		if len(found) > 0 {
+			first = append(first, stopDepartures{
+				stop:       stopDeps.stop,
+				departures: found,
+			})
+		}
+	}
+
+	return
+}
+
+/*
+allowedRoutes: Returns the route allow-list of a stop. A per-stop override in the
+config file takes precedence over the global routes list.
+*/
+func allowedRoutes(gtfsId string) []string {
+	// Config keys are case insensitive, viper hands them out in lower case
+	if routes, ok := configStopRoutes[strings.ToLower(gtfsId)]; ok {
+		return routes
+	}
+
+	return configRoutes
+}
+
+/*
+filterAllowedRoutes: Drops departures of routes that are not allowed for the stop.
+A "*" in the allow-list lets every route through.
+*/
+func filterAllowedRoutes(data routeData) (filtered routeData) {
+	allowed := allowedRoutes(data.stopDetails.gtfsId)
+
+	for _, route := range allowed {
+		if route == ALLROUTES {
+			return data
+		}
+	}
+
+	filtered.stopDetails = data.stopDetails
+	for _, arrDep := range data.arrDepDetails {
+		for _, route := range allowed {
+			if strings.EqualFold(arrDep.route, route) {
+				filtered.arrDepDetails = append(filtered.arrDepDetails, arrDep)
+				break
+			}
+		}
+	}
+
+	return
+}
+
+/*
+empty: Checks if the destination has neither headsigns nor stops.
+*/
+func (dest destinationSpec) empty() bool {
+	return len(dest.headsigns) == 0 && len(dest.stops) == 0
+}
+
+/*
+goesTo: Checks if the departure heads to the destination, either by its headsign or by
+stopping at one of the destination stops later on.
+*/
+func (arrDep routeArrDepDetails) goesTo(dest destinationSpec) bool {
+	for _, headSign := range dest.headsigns {
+		if strings.Contains(strings.ToLower(arrDep.headSign), strings.ToLower(headSign)) {
+			return true
+		}
+	}
+
+	for _, stop := range dest.stops {
+		for _, downstream := range arrDep.downstreamStops {
+			if strings.EqualFold(stop, downstream) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+departureTime: Returns the realtime departure when available, scheduled departure otherwise.
+*/
+func (arrDep routeArrDepDetails) departureTime() time.Time {
+	if arrDep.realtime {
+		return arrDep.realtimeDepartureTime
+	}
+
+	return arrDep.scheduledDepartureTime
+}
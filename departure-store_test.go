@@ -0,0 +1,176 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSetStopsDropsStops(t *testing.T) {
+	data := func(gtfsId string) routeData {
+		return routeData{stopDetails: stopStruct{gtfsId: gtfsId}}
+	}
+
+	tests := []struct {
+		name    string
+		stops   []string
+		updates []string
+		order   []string
+		missing []string
+	}{
+		{"stop kept", []string{"HSL:1", "HSL:3"}, []string{"HSL:1"}, []string{"HSL:1", "HSL:3"}, []string{"HSL:3"}},
+		{"late update of a dropped stop is discarded", []string{"HSL:1"}, []string{"HSL:2"}, []string{"HSL:1"}, nil},
+		{"update of an unknown stop is discarded", []string{"HSL:1", "HSL:2"}, []string{"HSL:9"}, []string{"HSL:1", "HSL:2"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newDepartureStore([]string{"HSL:1", "HSL:2"})
+			store.update("HSL:1", data("HSL:1"))
+			store.update("HSL:2", data("HSL:2"))
+
+			store.setStops(tt.stops)
+			for _, gtfsId := range tt.updates {
+				store.update(gtfsId, data(gtfsId))
+			}
+
+			if got := store.stopIds(); !reflect.DeepEqual(got, tt.order) {
+				t.Errorf("stopIds() = %q, want %q", got, tt.order)
+			}
+			if got := store.missing(); !reflect.DeepEqual(got, tt.missing) {
+				t.Errorf("missing() = %q, want %q", got, tt.missing)
+			}
+			for _, snap := range store.snapshots() {
+				if !store.known(snap.data.stopDetails.gtfsId) {
+					t.Errorf("snapshot of dropped stop %s kept", snap.data.stopDetails.gtfsId)
+				}
+			}
+		})
+	}
+}
+
+// loadTestRoutes loads the route allow-lists from config file JSON, like getConfig.
+func loadTestRoutes(t *testing.T, config string) {
+	v := viper.New()
+	v.SetConfigType("json")
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	configRoutes = v.GetStringSlice(ROUTES)
+	configStopRoutes = v.GetStringMapStringSlice(STOPROUTES)
+	t.Cleanup(func() { configRoutes, configStopRoutes = nil, nil })
+}
+
+func TestFilterAllowedRoutes(t *testing.T) {
+	config := `{
+		"routes": ["215", "214"],
+		"stopRoutes": {
+			"HSL:2143218": ["231N"],
+			"HSL:1040601": ["*"],
+			"HSL:9999999": []
+		}
+	}`
+
+	arrDeps := []routeArrDepDetails{{route: "215"}, {route: "214"}, {route: "231N"}, {route: "550"}}
+	routesOf := func(data routeData) (routes []string) {
+		for _, arrDep := range data.arrDepDetails {
+			routes = append(routes, arrDep.route)
+		}
+		return
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		gtfsId string
+		want   []string
+	}{
+		{"global allow-list", config, "HSL:1", []string{"215", "214"}},
+		{"per-stop override", config, "HSL:2143218", []string{"231N"}},
+		{"per-stop wildcard", config, "HSL:1040601", []string{"215", "214", "231N", "550"}},
+		{"per-stop override allowing nothing", config, "HSL:9999999", nil},
+		{"global wildcard", `{"routes": ["215", "*"]}`, "HSL:1", []string{"215", "214", "231N", "550"}},
+		{"route case ignored", `{"routes": ["231n"]}`, "HSL:1", []string{"231N"}},
+		{"nothing allowed", `{}`, "HSL:1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestRoutes(t, tt.config)
+
+			data := routeData{stopDetails: stopStruct{gtfsId: tt.gtfsId}, arrDepDetails: arrDeps}
+			filtered := filterAllowedRoutes(data)
+
+			if got := routesOf(filtered); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterAllowedRoutes() kept %q, want %q", got, tt.want)
+			}
+			if filtered.stopDetails.gtfsId != tt.gtfsId {
+				t.Errorf("stop %q, want %q", filtered.stopDetails.gtfsId, tt.gtfsId)
+			}
+		})
+	}
+}
+
+func TestAllowedRoutesLowerCaseKeys(t *testing.T) {
+	loadTestRoutes(t, `{"routes": ["215"], "stopRoutes": {"HSL:2143218": ["231N"]}}`)
+
+	// Viper hands the per-stop keys out in lower case
+	if _, ok := configStopRoutes["HSL:2143218"]; ok {
+		t.Fatalf("viper kept the key case, %v", configStopRoutes)
+	}
+
+	tests := []struct {
+		gtfsId string
+		want   []string
+	}{
+		{"HSL:2143218", []string{"231N"}},
+		{"hsl:2143218", []string{"231N"}},
+		{"HSL:2143219", []string{"215"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.gtfsId, func(t *testing.T) {
+			if got := allowedRoutes(tt.gtfsId); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allowedRoutes(%q) = %q, want %q", tt.gtfsId, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoesTo(t *testing.T) {
+	arrDep := routeArrDepDetails{
+		route:           "215",
+		headSign:        "Leppävaara via Sello",
+		downstreamStops: []string{"HSL:2222234", "HSL:1234567"},
+	}
+	noStops := routeArrDepDetails{route: "215", headSign: "Leppävaara"}
+
+	tests := []struct {
+		name   string
+		arrDep routeArrDepDetails
+		dest   destinationSpec
+		want   bool
+	}{
+		{"headsign", arrDep, destinationSpec{headsigns: []string{"Leppävaara"}}, true},
+		{"part of headsign, case ignored", arrDep, destinationSpec{headsigns: []string{"sello"}}, true},
+		{"one of headsigns", arrDep, destinationSpec{headsigns: []string{"Kamppi", "Sello"}}, true},
+		{"other headsign", arrDep, destinationSpec{headsigns: []string{"Kamppi"}}, false},
+		{"downstream stop", arrDep, destinationSpec{stops: []string{"HSL:1234567"}}, true},
+		{"downstream stop, case ignored", arrDep, destinationSpec{stops: []string{"hsl:2222234"}}, true},
+		{"stop not on the way", arrDep, destinationSpec{stops: []string{"HSL:1040601"}}, false},
+		{"stop without downstream stops", noStops, destinationSpec{stops: []string{"HSL:2222234"}}, false},
+		{"stop when headsign differs", arrDep, destinationSpec{headsigns: []string{"Kamppi"}, stops: []string{"HSL:2222234"}}, true},
+		{"empty destination", arrDep, destinationSpec{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.arrDep.goesTo(tt.dest); got != tt.want {
+				t.Errorf("goesTo(%v) = %v, want %v", tt.dest, got, tt.want)
+			}
+		})
+	}
+}
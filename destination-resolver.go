@@ -0,0 +1,264 @@
+/*
+destination-resolver.go
+
+Resolves spoken destinations into configured destinations.
+- Both the spoken destination and the configured call signs, headsigns and aliases are
+  normalised: articles, possessives and plurals are dropped and Finnish letters folded.
+- Candidates are scored by Levenshtein similarity, with Soundex as a phonetic fallback
+  for misheard words, e.g. "sellow" for "Sello".
+- The best candidate is returned with a confidence, so a weak match can be confirmed
+  with the user before answering.
+*/
+
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Minimum confidence to answer right away, and to suggest a destination at all
+const (
+	destinationConfident float64 = 0.8
+	destinationPlausible float64 = 0.5
+)
+
+// Confidence given to phrases that only sound alike
+const soundexConfidence float64 = 0.75
+
+// Articles that are dropped from destinations, e.g. "the sports hall"
+var destinationArticles = map[string]bool{
+	"the": true,
+	"a":   true,
+	"an":  true,
+}
+
+// Alternative names per headsign, from config file
+var configHeadsignAliases map[string][]string
+
+// A phrase that resolves to a destination
+type destinationCandidate struct {
+	phrase      string
+	callSign    string
+	destination destinationSpec
+}
+
+// Outcome of resolving a spoken destination
+type destinationResolution struct {
+	callSign    string
+	destination destinationSpec
+	confidence  float64
+}
+
+/*
+normaliseDestination: Normalises a destination for comparison, e.g. "The Sports
+Hall's" becomes "sports hall" and "Lähderanta" becomes "lahderanta".
+*/
+func normaliseDestination(destination string) string {
+	var words []string
+
+	folded := strings.Map(func(r rune) rune {
+		switch r {
+		case 'ä', 'å':
+			return 'a'
+		case 'ö':
+			return 'o'
+		case '\'', '’':
+			return '\''
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, strings.ToLower(destination))
+
+	for _, word := range strings.Fields(folded) {
+		word = strings.TrimSuffix(word, "'s")
+		word = strings.Trim(word, "'")
+
+		if word == "" || destinationArticles[word] {
+			continue
+		}
+
+		// Plural forms, e.g. "sports halls". Short words are left alone.
+		if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+			word = strings.TrimSuffix(word, "s")
+		}
+
+		words = append(words, word)
+	}
+
+	return strings.Join(words, " ")
+}
+
+/*
+destinationCandidates: Returns every phrase a destination can be referred to with:
+call signs, headsigns themselves and configured aliases of headsigns.
+*/
+func destinationCandidates() (candidates []destinationCandidate) {
+	for callSign, dest := range configSigns {
+		candidates = append(candidates,
+			destinationCandidate{phrase: normaliseDestination(callSign), callSign: callSign, destination: dest})
+
+		for _, headSign := range dest.headsigns {
+			candidates = append(candidates,
+				destinationCandidate{phrase: normaliseDestination(headSign), callSign: callSign, destination: dest})
+
+			for aliasedSign, aliases := range configHeadsignAliases {
+				if !strings.EqualFold(aliasedSign, headSign) {
+					continue
+				}
+				for _, alias := range aliases {
+					candidates = append(candidates,
+						destinationCandidate{phrase: normaliseDestination(alias), callSign: callSign, destination: dest})
+				}
+			}
+		}
+	}
+
+	return
+}
+
+/*
+resolveDestination: Finds the destination the user most likely meant. Confidence is 1 for
+an exact match after normalisation, and 0 when nothing resembles the destination.
+*/
+func resolveDestination(destination string) (resolution destinationResolution) {
+	spoken := normaliseDestination(destination)
+	if spoken == "" {
+		return
+	}
+
+	for _, candidate := range destinationCandidates() {
+		confidence := similarity(spoken, candidate.phrase)
+
+		if confidence < soundexConfidence && soundex(spoken) == soundex(candidate.phrase) {
+			confidence = soundexConfidence
+		}
+
+		// Ties go to the alphabetically first call sign, so answers are stable
+		if confidence > resolution.confidence ||
+			(confidence == resolution.confidence && candidate.callSign < resolution.callSign) {
+			resolution = destinationResolution{
+				callSign:    candidate.callSign,
+				destination: candidate.destination,
+				confidence:  confidence,
+			}
+		}
+	}
+
+	return
+}
+
+/*
+similarity: Levenshtein distance scaled into [0, 1], 1 meaning equal strings.
+*/
+func similarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+/*
+levenshtein: Number of single character edits to turn a into b.
+*/
+func levenshtein(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+/*
+soundex: American Soundex code of every word of the phrase, e.g. "sello" is "S400".
+*/
+func soundex(phrase string) string {
+	var codes []string
+
+	for _, word := range strings.Fields(phrase) {
+		var code []byte
+		var last byte
+
+		for indx, r := range word {
+			digit := soundexDigit(r)
+			if indx == 0 {
+				code = append(code, byte(unicode.ToUpper(r)))
+				last = digit
+				continue
+			}
+
+			// h and w do not separate letters with the same code
+			if r == 'h' || r == 'w' {
+				continue
+			}
+
+			if digit != '0' && digit != last {
+				code = append(code, digit)
+			}
+			last = digit
+
+			if len(code) == 4 {
+				break
+			}
+		}
+
+		for len(code) < 4 {
+			code = append(code, '0')
+		}
+		codes = append(codes, string(code))
+	}
+
+	return strings.Join(codes, " ")
+}
+
+/*
+soundexDigit: Helper function - Soundex digit of a letter, '0' for vowels and others.
+*/
+func soundexDigit(r rune) byte {
+	switch r {
+	case 'b', 'f', 'p', 'v':
+		return '1'
+	case 'c', 'g', 'j', 'k', 'q', 's', 'x', 'z':
+		return '2'
+	case 'd', 't':
+		return '3'
+	case 'l':
+		return '4'
+	case 'm', 'n':
+		return '5'
+	case 'r':
+		return '6'
+	default:
+		return '0'
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestResolveDestination(t *testing.T) {
+	configSigns = map[string]destinationSpec{
+		"Sello":       {headsigns: []string{"Leppävaara"}},
+		"Sports Hall": {headsigns: []string{"Lähderanta"}},
+		"Tapiola":     {headsigns: []string{"Tapiola"}},
+		"Helsinki":    {headsigns: []string{"Elielinaukio", "Kamppi"}},
+	}
+	configHeadsignAliases = map[string][]string{"lähderanta": {"Urheiluhalli"}}
+	defer func() { configSigns, configHeadsignAliases = nil, nil }()
+
+	tests := []struct {
+		name         string
+		spoken       string
+		callSign     string
+		minimum      float64
+		belowMinimum float64
+	}{
+		{"call sign", "Sello", "Sello", 1, 0},
+		{"call sign in lower case", "sello", "Sello", 1, 0},
+		{"article and plural", "the sports halls", "Sports Hall", 1, 0},
+		{"possessive", "Sports Hall's", "Sports Hall", 1, 0},
+		{"headsign", "Leppävaara", "Sello", 1, 0},
+		{"headsign without Finnish letters", "leppavaara", "Sello", 1, 0},
+		{"one of several headsigns", "Kamppi", "Helsinki", 1, 0},
+		{"alias of a headsign", "urheiluhalli", "Sports Hall", 1, 0},
+		{"misheard is confident", "sellow", "Sello", destinationConfident, 0},
+		{"sounds alike is plausible", "sallow", "Sello", destinationPlausible, destinationConfident},
+		{"two typos are plausible", "tappiolla", "Tapiola", destinationPlausible, destinationConfident},
+		{"small typo is confident", "tapiolla", "Tapiola", destinationConfident, 0},
+		{"nothing alike", "airport", "", 0, destinationPlausible},
+		{"empty", "", "", 0, destinationPlausible},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved := resolveDestination(tt.spoken)
+
+			if resolved.confidence < tt.minimum {
+				t.Errorf("confidence = %v, want at least %v", resolved.confidence, tt.minimum)
+			}
+			if tt.belowMinimum > 0 && resolved.confidence >= tt.belowMinimum {
+				t.Errorf("confidence = %v, want below %v", resolved.confidence, tt.belowMinimum)
+			}
+			if tt.callSign != "" && resolved.callSign != tt.callSign {
+				t.Errorf("call sign = %q, want %q", resolved.callSign, tt.callSign)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+/*
+dialogflow-cx.go
+
+Webhook for Dialogflow CX agents.
+- The fulfillment tag selects the registered intent, using the same names as the ES intents:
+  Destination-Only, Bus-Destination, Next-After or Change-Route.
+- Route and destination are read from session parameters, falling back to the
+  parameters of the matched intent.
+- Queries are answered by the same logic as the ES webhook, only the response format differs.
+- The last query is kept in the "route-followup" session parameter for follow-up questions.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+cxParam: Returns a parameter of the CX request. Session parameters take precedence
+over the parameters of the matched intent.
+*/
+func (cxReq cxWebhookRequest) cxParam(name string) (value interface{}, ok bool) {
+	if value, ok = cxReq.SessionInfo.Parameters[name]; ok && value != nil {
+		return
+	}
+
+	if cxReq.IntentInfo != nil {
+		var param cxIntentParameterValue
+		if param, ok = cxReq.IntentInfo.Parameters[name]; ok {
+			value = param.ResolvedValue
+			return
+		}
+	}
+
+	return nil, false
+}
+
+/*
+extractCxParams: Decodes the Webhook Request received from Dialogflow CX.
+*/
+func extractCxParams(r *http.Request) (cxReq cxWebhookRequest) {
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&cxReq); err != nil {
+		log.Error("Error decoding json body in CX request - ", err)
+	}
+
+	return
+}
+
+/*
+previousQuery: Returns the query kept in the follow-up session parameter, an empty
+query if there is none.
+*/
+func (cxReq cxWebhookRequest) previousQuery() routeQuery {
+	params, _ := cxReq.SessionInfo.Parameters[FOLLOWUPCONTEXT].(map[string]interface{})
+
+	return queryFromParameters(params)
+}
+
+/*
+GetCxRouteHandler: Webserver handler for Dialogflow CX. Answers the query like
+GetRouteHandler and formats the answer into CX Webhook Response format.
+*/
+func GetCxRouteHandler(w http.ResponseWriter, r *http.Request) {
+
+	cxReq := extractCxParams(r)
+	log.Info("CX WebHook Req for tag - ", cxReq.FulfillmentInfo.Tag)
+
+	answer := dispatchIntent(cxReq.FulfillmentInfo.Tag, cxReq.cxParam,
+		replyLanguage(cxReq.LanguageCode), cxReq.previousQuery())
+
+	// Text messages are shown, the SSML message is spoken
+	var messages []cxResponseMessage
+	for _, speech := range answer.speech {
+		messages = append(messages, cxResponseMessage{
+			Text: &cxText{Text: []string{speech.text}},
+		})
+	}
+	messages = append(messages, cxResponseMessage{
+		OutputAudioText: &cxOutputAudioText{Ssml: joinSpeech(answer.speech, " ").speak()},
+	})
+
+	cxResp := cxWebhookResponse{
+		FulfillmentResponse: &cxFulfillmentResponse{
+			Messages: messages,
+		},
+	}
+
+	if answer.followup.destination != "" {
+		cxResp.SessionInfo = &cxSessionInfo{
+			Parameters: map[string]interface{}{FOLLOWUPCONTEXT: answer.followup.parameters()},
+		}
+	}
+
+	if answer.status == answerError {
+		log.Error("CX WebHook ERROR RESP - ", cxResp)
+	} else {
+		log.Info("CX WebHook RESP - ", cxResp)
+	}
+	respondWithJSON(w, http.StatusOK, cxResp)
+}
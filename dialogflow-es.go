@@ -0,0 +1,102 @@
+/*
+dialogflow-es.go
+
+Helpers around the Dialogflow ES v2 webhook models defined in types.go.
+- Reading and writing output contexts of a session.
+- Reading typed parameters from the query result.
+- Keeping the last query in the follow-up context, for Next-After and Change-Route.
+*/
+
+package main
+
+import (
+	"strings"
+)
+
+// Number of conversational turns the follow-up context stays active
+const followupLifespan int = 5
+
+/*
+context: Returns the active context with the given id, e.g. "route-followup" for
+"projects/p/agent/sessions/s/contexts/route-followup". Ids are case insensitive.
+*/
+func (webHookReq dfWebhookRequest) context(id string) (ctx dfContext, ok bool) {
+	for _, c := range webHookReq.QueryResult.OutputContexts {
+		if strings.EqualFold(contextId(c.Name), id) {
+			return c, true
+		}
+	}
+
+	return
+}
+
+/*
+newContext: Creates an output context with the given id for the session of the request.
+*/
+func (webHookReq dfWebhookRequest) newContext(id string, lifespan int, parameters map[string]interface{}) dfContext {
+	return dfContext{
+		Name:          webHookReq.Session + "/contexts/" + id,
+		LifespanCount: lifespan,
+		Parameters:    parameters,
+	}
+}
+
+/*
+contextId: Helper function - Returns the last path segment of a context name.
+*/
+func contextId(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+/*
+stringParam: Returns a string parameter of the context, empty if missing.
+*/
+func (ctx dfContext) stringParam(name string) string {
+	value, _ := ctx.Parameters[name].(string)
+
+	return value
+}
+
+/*
+numberParam: Returns a numeric parameter of the context, 0 if missing.
+JSON numbers are decoded as float64.
+*/
+func (ctx dfContext) numberParam(name string) int {
+	value, _ := ctx.Parameters[name].(float64)
+
+	return int(value)
+}
+
+/*
+queryParam: Returns a parameter of the query result.
+*/
+func (webHookReq dfWebhookRequest) queryParam(name string) (value interface{}, ok bool) {
+	value, ok = webHookReq.QueryResult.Parameters[name]
+
+	return
+}
+
+/*
+previousQuery: Returns the query kept in the follow-up context of the conversation,
+an empty query if there is none.
+*/
+func (webHookReq dfWebhookRequest) previousQuery() routeQuery {
+	ctx, ok := webHookReq.context(FOLLOWUPCONTEXT)
+	if !ok {
+		return routeQuery{}
+	}
+
+	return queryFromParameters(ctx.Parameters)
+}
+
+/*
+followupContexts: Returns the output contexts keeping the follow-up query of the answer,
+none if there is nothing to follow up.
+*/
+func (webHookReq dfWebhookRequest) followupContexts(answer routeAnswer) []dfContext {
+	if answer.followup.destination == "" {
+		return nil
+	}
+
+	return []dfContext{webHookReq.newContext(FOLLOWUPCONTEXT, followupLifespan, answer.followup.parameters())}
+}
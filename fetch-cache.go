@@ -0,0 +1,165 @@
+/*
+fetch-cache.go
+
+On-demand fetching of departures when a query arrives.
+- Departures of a stop are re-fetched from HSL API when the stored snapshot is older
+  than the configured TTL.
+- Concurrent fetches of the same stop are deduplicated (single-flight), so a burst of
+  webhook requests causes only one HSL API call per stop.
+- Queries arriving while a stop is fetched wait for that fetch instead of answering
+  from stale data.
+- Failed fetches are not retried for the stop until the TTL has passed again.
+- Stops without data, or with data older than the TTL that could not be fetched again
+  recently, are reported as unavailable.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Maximum age of stored departures before a query triggers a fetch. 0 disables it.
+var cacheTTL time.Duration
+
+// An in-flight fetch of a single stop
+type fetchCall struct {
+	done chan struct{}
+	ok   bool
+}
+
+// Deduplicates concurrent fetches per stop
+type fetchGroup struct {
+	lock   sync.Mutex
+	calls  map[string]*fetchCall
+	failed map[string]time.Time
+}
+
+var stopFetches = fetchGroup{
+	calls:  make(map[string]*fetchCall),
+	failed: make(map[string]time.Time),
+}
+
+/*
+do: Runs fetch for the stop unless a fetch for it is already in flight, in which
+case the result of the in-flight fetch is waited for and shared. The time of a failed
+fetch is kept for backing off, a successful fetch clears it. A group without failed
+map keeps no failures.
+*/
+func (fg *fetchGroup) do(gtfsId string, fetch func() bool) bool {
+	fg.lock.Lock()
+	if call, ok := fg.calls[gtfsId]; ok {
+		fg.lock.Unlock()
+		<-call.done
+		return call.ok
+	}
+
+	call := &fetchCall{done: make(chan struct{})}
+	fg.calls[gtfsId] = call
+	fg.lock.Unlock()
+
+	call.ok = fetch()
+
+	fg.lock.Lock()
+	delete(fg.calls, gtfsId)
+	if call.ok {
+		delete(fg.failed, gtfsId)
+	} else if fg.failed != nil {
+		fg.failed[gtfsId] = time.Now()
+	}
+	fg.lock.Unlock()
+	close(call.done)
+
+	return call.ok
+}
+
+/*
+lastFailure: Returns when the last fetch of the stop failed, zero time if it succeeded.
+*/
+func (fg *fetchGroup) lastFailure(gtfsId string) time.Time {
+	fg.lock.Lock()
+	defer fg.lock.Unlock()
+
+	return fg.failed[gtfsId]
+}
+
+/*
+fetchStop: Fetches departures of a stop from HSL API and stores them in the departure
+store. Returns false if the fetch failed, in which case the stored data is untouched.
+*/
+func fetchStop(gtfsId string) bool {
+	return stopFetches.do(gtfsId, func() bool {
+		routeInf, err := buildRouteData(gtfsId)
+
+		if err != nil {
+			if isFetchError(err, unknownStopError) {
+				log.Error("Stop not known to HSL API, check stopGtfsIds in config file - ", err)
+			} else {
+				log.Error("Fetch failed, keeping last good data - ", err)
+			}
+			return false
+		}
+
+		departureData.update(gtfsId, filterAllowedRoutes(routeInf))
+		return true
+	})
+}
+
+/*
+ensureFresh: Fetches, in parallel, every configured stop whose stored departures are
+older than the TTL. Stops already being fetched are waited for. Returns once all of
+them are done.
+*/
+func ensureFresh(ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	var fetches sync.WaitGroup
+	now := time.Now()
+
+	for _, stop := range departureData.stopIds() {
+		snap, ok := departureData.stop(stop)
+		if ok && now.Sub(snap.updated) < ttl {
+			continue
+		}
+
+		if now.Sub(stopFetches.lastFailure(stop)) < ttl {
+			// Recently failed, do not hammer the API
+			continue
+		}
+
+		log.Debug("Departures of stop - ", stop, " are stale, fetching")
+		fetches.Add(1)
+		go func(gtfsId string) {
+			defer fetches.Done()
+			fetchStop(gtfsId)
+		}(stop)
+	}
+
+	fetches.Wait()
+}
+
+/*
+unavailableStops: Returns the stops whose departures cannot be trusted: stops never
+fetched, and stops whose data is older than the TTL because their last fetch, within
+the TTL, failed. Their stored departures may all have left already.
+*/
+func unavailableStops(ttl time.Duration, now time.Time) (gtfsIds []string) {
+	gtfsIds = departureData.missing()
+	if ttl <= 0 {
+		return
+	}
+
+	for _, stop := range departureData.stopIds() {
+		snap, ok := departureData.stop(stop)
+		if ok && now.Sub(snap.updated) >= ttl && now.Sub(stopFetches.lastFailure(stop)) < ttl {
+			gtfsIds = append(gtfsIds, stop)
+		}
+	}
+
+	return
+}
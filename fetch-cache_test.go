@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFetchGroup() *fetchGroup {
+	return &fetchGroup{
+		calls:  make(map[string]*fetchCall),
+		failed: make(map[string]time.Time),
+	}
+}
+
+func TestFetchGroupDoSharesInFlightFetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		callers int
+		result  bool
+	}{
+		{"single caller", 1, true},
+		{"concurrent callers succeed", 10, true},
+		{"concurrent callers fail", 10, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fg := newTestFetchGroup()
+			release := make(chan struct{})
+			var fetches int32
+
+			fetch := func() bool {
+				atomic.AddInt32(&fetches, 1)
+				<-release
+				return tt.result
+			}
+
+			var started, done sync.WaitGroup
+			results := make([]bool, tt.callers)
+			for i := 0; i < tt.callers; i++ {
+				started.Add(1)
+				done.Add(1)
+				go func(i int) {
+					defer done.Done()
+					started.Done()
+					results[i] = fg.do("HSL:1", fetch)
+				}(i)
+			}
+
+			// Let every caller reach the in-flight fetch before it completes
+			started.Wait()
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			done.Wait()
+
+			if fetches != 1 {
+				t.Errorf("fetch ran %d times, want 1", fetches)
+			}
+			for i, ok := range results {
+				if ok != tt.result {
+					t.Errorf("caller %d got %v, want %v", i, ok, tt.result)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchGroupDoRecordsFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		results    []bool
+		wantFailed bool
+	}{
+		{"success", []bool{true}, false},
+		{"failure", []bool{false}, true},
+		{"success after failure", []bool{false, true}, false},
+		{"failure after success", []bool{true, false}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fg := newTestFetchGroup()
+
+			for _, result := range tt.results {
+				result := result
+				fg.do("HSL:1", func() bool { return result })
+			}
+
+			if failed := !fg.lastFailure("HSL:1").IsZero(); failed != tt.wantFailed {
+				t.Errorf("lastFailure set = %v, want %v", failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestFetchGroupDoNoFailureWhileInFlight(t *testing.T) {
+	fg := newTestFetchGroup()
+	release := make(chan struct{})
+	go fg.do("HSL:1", func() bool {
+		<-release
+		return true
+	})
+	defer close(release)
+
+	time.Sleep(20 * time.Millisecond)
+	if !fg.lastFailure("HSL:1").IsZero() {
+		t.Error("an in-flight fetch must not count as a failure")
+	}
+}
+
+func TestUnavailableStops(t *testing.T) {
+	now := time.Now()
+	ttl := time.Minute
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		updated time.Time
+		failed  time.Time
+		want    []string
+	}{
+		{"fresh data", ttl, now, time.Time{}, []string{"HSL:2"}},
+		{"stale data, fetch not tried", ttl, now.Add(-2 * ttl), time.Time{}, []string{"HSL:2"}},
+		{"stale data, fetch failed recently", ttl, now.Add(-2 * ttl), now.Add(-ttl / 2), []string{"HSL:2", "HSL:1"}},
+		{"stale data, fetch failed long ago", ttl, now.Add(-3 * ttl), now.Add(-2 * ttl), []string{"HSL:2"}},
+		{"fresh data after failure", ttl, now, now.Add(-ttl / 2), []string{"HSL:2"}},
+		{"TTL disabled", 0, now.Add(-2 * ttl), now.Add(-ttl / 2), []string{"HSL:2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			departureData = newDepartureStore([]string{"HSL:1", "HSL:2"})
+			departureData.update("HSL:1", routeData{stopDetails: stopStruct{gtfsId: "HSL:1"}})
+			departureData.stops["HSL:1"].updated = tt.updated
+
+			stopFetches.lock.Lock()
+			stopFetches.failed["HSL:1"] = tt.failed
+			stopFetches.lock.Unlock()
+
+			t.Cleanup(func() {
+				departureData = nil
+				stopFetches.lock.Lock()
+				delete(stopFetches.failed, "HSL:1")
+				stopFetches.lock.Unlock()
+			})
+
+			if got := unavailableStops(tt.ttl, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unavailableStops() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
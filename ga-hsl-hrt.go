@@ -4,29 +4,37 @@ ga-hsl-hrt.go
 Main file for the webserver implementation
 - Configuration parsing and updating is done here.
 - Logging is enabled.
-- Main entry point for the webserver
+- Main entry point for the webserver and the command line client
 */
 
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
+	"time"
 	"github.com/spf13/viper"
 	log "github.com/sirupsen/logrus"
 )
 
 // Main structure that holds routes retrieved from HSL API
-var routeInfo []routeData
+var departureData *departureStore
 
 // Configuration parameters
 var configRoutes []string
-var configSigns map[string]string
+var configStopRoutes map[string][]string
+var configSigns map[string]destinationSpec
 var configStopGtfsIds []string
 var listeningPort string
 var clientCaCert string
 var serverCert string
 var serverKey string
+var departureGrace time.Duration
+var relativeTimeWindow time.Duration
 
 // Logfile
 var file *os.File
@@ -60,6 +68,17 @@ func getConfig() {
 	viper.SetConfigType("json")
 	viper.AddConfigPath(".")
 
+	// Optional parameters
+	viper.SetDefault(REFRESHINTERVAL, DEFAULTREFRESHINTERVAL)
+	viper.SetDefault(RUSHINTERVAL, DEFAULTRUSHINTERVAL)
+	viper.SetDefault(REFRESHJITTER, DEFAULTREFRESHJITTER)
+	viper.SetDefault(CACHETTL, DEFAULTCACHETTL)
+	viper.SetDefault(DEPARTUREGRACE, DEFAULTDEPARTUREGRACE)
+	viper.SetDefault(RELATIVEWINDOW, DEFAULTRELATIVEWINDOW)
+	viper.SetDefault(TELEGRAMAPIURL, DEFAULTTELEGRAMAPIURL)
+	viper.SetDefault(HOMERADIUS, DEFAULTHOMERADIUS)
+	viper.SetDefault(NEARBYINTERVAL, DEFAULTNEARBYINTERVAL)
+
 	err := viper.ReadInConfig() // Find and read the config file
 	if err != nil { // Handle errors reading the config file
 		log.Panic("Fatal error config file: ", err)
@@ -67,13 +86,29 @@ func getConfig() {
 
 	// Get configuration parameters
 	configRoutes = viper.GetStringSlice(ROUTES)
-	configSigns = viper.GetStringMapString(SIGNS)
+	configStopRoutes = viper.GetStringMapStringSlice(STOPROUTES)
+	configHeadsignAliases = viper.GetStringMapStringSlice(SIGNALIASES)
 	listeningPort = viper.GetString(PORT)
 	logFile := viper.GetString(LOGFILE)
 	clientCaCert = viper.GetString(CLIENTCERT)
 	serverCert = viper.GetString(SERVERCERT)
 	serverKey = viper.GetString(SERVERKEY)
 	configStopGtfsIds = viper.GetStringSlice(STOPGTFSIDS)
+	refreshInterval = viper.GetDuration(REFRESHINTERVAL)
+	rushHourInterval = viper.GetDuration(RUSHINTERVAL)
+	refreshJitter = viper.GetDuration(REFRESHJITTER)
+	cacheTTL = viper.GetDuration(CACHETTL)
+	departureGrace = viper.GetDuration(DEPARTUREGRACE)
+	relativeTimeWindow = viper.GetDuration(RELATIVEWINDOW)
+	alexaSkillId = viper.GetString(ALEXASKILLID)
+	telegramToken = viper.GetString(TELEGRAMTOKEN)
+	telegramApiUrl = viper.GetString(TELEGRAMAPIURL)
+	homeConfigured = viper.IsSet(HOMELATITUDE) && viper.IsSet(HOMELONGITUDE)
+	homeLatitude = viper.GetFloat64(HOMELATITUDE)
+	homeLongitude = viper.GetFloat64(HOMELONGITUDE)
+	homeRadius = viper.GetInt(HOMERADIUS)
+	homeModes = viper.GetStringSlice(HOMEMODES)
+	discoveryInterval = viper.GetDuration(NEARBYINTERVAL)
 
 	if logFile == "" {
 		panic("No logfile defined!")
@@ -85,14 +120,93 @@ func getConfig() {
 		log.Panic("No routes defined!")
 	}
 
+	if configSigns, err = parseDestinations(viper.GetStringMap(SIGNS)); err != nil {
+		log.Panic("Invalid headsigns in config file: ", err)
+	}
+	configSigns = callSignSpelling(configSigns, viper.ConfigFileUsed())
+
 	if len(configSigns) == 0 {
 		log.Panic("No headsigns defined!")
 	}
 
-	if len(configStopGtfsIds) == 0 {
-		log.Panic("No stopGtfsIds defined!")
+	// Stops near home are discovered, then stopGtfsIds are optional
+	if len(configStopGtfsIds) == 0 && !homeConfigured {
+		log.Panic("No stopGtfsIds or home coordinate defined!")
+	}
+
+	if telegramAllowedChats, err = parseTelegramChats(viper.GetStringSlice(TELEGRAMCHATS)); err != nil {
+		log.Panic("Invalid telegramChats in config file: ", err)
 	}
 
+	if homeConfigured && (homeRadius <= 0 || discoveryInterval <= 0) {
+		log.Panic("Home radius and stop discovery interval must be positive!")
+	}
+
+	if refreshInterval <= 0 || rushHourInterval <= 0 {
+		log.Panic("Refresh intervals must be positive durations, e.g. \"10m\"!")
+	}
+
+	if rushHours, err = parseRushHours(viper.GetStringSlice(RUSHHOURS)); err != nil {
+		log.Panic("Invalid rush hours in config file: ", err)
+	}
+
+	log.Info("routes - ", configRoutes)
+	log.Info("stopRoutes - ", configStopRoutes)
+	log.Info("callsigns - ", configSigns)
+	log.Info("headsignAliases - ", configHeadsignAliases)
+	log.Info("stopgtfsids - ", configStopGtfsIds)
+	log.Info("port - ", listeningPort)
+	log.Info("serverCert - ", serverCert)
+	log.Info("serverKey - ", serverKey)
+	log.Info("clientCert - ", clientCaCert)
+	log.Info("refreshInterval - ", refreshInterval)
+	log.Info("rushHourRefreshInterval - ", rushHourInterval)
+	log.Info("rushHours - ", viper.GetStringSlice(RUSHHOURS))
+	log.Info("refreshJitter - ", refreshJitter)
+	log.Info("cacheTTL - ", cacheTTL)
+	log.Info("departureGrace - ", departureGrace)
+	log.Info("relativeTimeWindow - ", relativeTimeWindow)
+	log.Info("alexaSkillId - ", alexaSkillId)
+	// The bot token is a secret, only log if the bot is enabled
+	log.Info("telegramBot - ", telegramToken != "")
+	log.Info("telegramApiUrl - ", telegramApiUrl)
+	log.Info("telegramChats - ", viper.GetStringSlice(TELEGRAMCHATS))
+	if homeConfigured {
+		log.Info("home - ", homeLatitude, ", ", homeLongitude)
+		log.Info("homeRadius - ", homeRadius)
+		log.Info("homeModes - ", homeModes)
+		log.Info("stopDiscoveryInterval - ", discoveryInterval)
+	}
+
+	return
+}
+
+/*
+resolveStopConfig: Resolves stops given by code or name in the configuration into
+gtfsIds. Newly resolved stops are kept in the stop lookup file only if saveLookup is
+set. Stops or call signs left empty will cause a non recoverable panic!
+*/
+func resolveStopConfig(saveLookup bool) {
+	resolveConfiguredStops(saveLookup)
+
+	if len(configStopGtfsIds) == 0 && !homeConfigured {
+		log.Panic("None of the stopGtfsIds could be resolved!")
+	}
+
+	// Call signs whose stops could not be resolved are dropped
+	if len(configSigns) == 0 {
+		log.Panic("None of the call signs have headsigns or resolved stops!")
+	}
+
+	log.Info("resolved stopgtfsids - ", configStopGtfsIds)
+	log.Info("resolved callsigns - ", configSigns)
+}
+
+/*
+checkServerConfig: Checks the configuration parameters only the webserver needs.
+Missing parameters will cause a non recoverable panic!
+*/
+func checkServerConfig() {
 	if listeningPort == "" {
 		log.Panic("Server port not defined in config file!")
 	}
@@ -108,32 +222,143 @@ func getConfig() {
 	if clientCaCert == "" {
 		log.Panic("Client certificate location not defined in config file!")
 	}
+}
 
-	log.Info("routes - ", configRoutes)
-	log.Info("callsigns - ", configSigns)
-	log.Info("stopgtfsids - ", configStopGtfsIds)
-	log.Info("port - ", listeningPort)
-	log.Info("serverCert - ", serverCert)
-	log.Info("serverKey - ", serverKey)
-	log.Info("clientCert - ", clientCaCert)
+/*
+parseDestinations: Parses the call sign to headsign mapping of the config file. A call
+sign maps to a single headsign, a list of headsigns, or an object with "headsigns"
+and/or "stops" lists, e.g. {"headsigns": ["Kamppi"], "stops": ["HSL:1040601"]}.
+*/
+func parseDestinations(raw map[string]interface{}) (destinations map[string]destinationSpec, err error) {
+	destinations = make(map[string]destinationSpec)
+
+	for callSign, value := range raw {
+		var dest destinationSpec
+
+		switch spec := value.(type) {
+		case map[string]interface{}:
+			if dest.headsigns, err = toStringSlice(spec["headsigns"]); err != nil {
+				return
+			}
+			if dest.stops, err = toStringSlice(spec["stops"]); err != nil {
+				return
+			}
+		default:
+			if dest.headsigns, err = toStringSlice(spec); err != nil {
+				return
+			}
+		}
+
+		if len(dest.headsigns) == 0 && len(dest.stops) == 0 {
+			err = fmt.Errorf("no headsigns or stops for call sign %q", callSign)
+			return
+		}
+
+		destinations[callSign] = dest
+	}
 
 	return
 }
 
 /*
-main: Exatly what it says!! Main function
+callSignSpelling: Re-keys the destinations with the call signs as written in the config
+file. Viper hands out keys in lower case, which would show in prompts and chips, and
+keeps no original spelling, so the file is read again for it. Destinations are returned
+as they are if the file or its call signs cannot be read.
+*/
+func callSignSpelling(destinations map[string]destinationSpec, configFile string) map[string]destinationSpec {
+	var raw map[string]json.RawMessage
+	data, err := ioutil.ReadFile(configFile)
+	if err == nil {
+		err = json.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		log.Error("Call sign spelling could not be read from config file - ", err)
+		return destinations
+	}
+
+	var signs map[string]json.RawMessage
+	for key, value := range raw {
+		if strings.EqualFold(key, SIGNS) {
+			if err = json.Unmarshal(value, &signs); err != nil {
+				log.Error("Call sign spelling could not be read from config file - ", err)
+				return destinations
+			}
+		}
+	}
+
+	spelled := make(map[string]destinationSpec)
+	for callSign, dest := range destinations {
+		for written := range signs {
+			if strings.EqualFold(written, callSign) {
+				callSign = written
+				break
+			}
+		}
+		spelled[callSign] = dest
+	}
+
+	return spelled
+}
+
+/*
+toStringSlice: Helper function - Converts a config value that is a string or a list
+of strings into a string slice. A missing value gives an empty slice.
+*/
+func toStringSlice(value interface{}) (strs []string, err error) {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		strs = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				err = fmt.Errorf("expected a string, got %v", item)
+				return
+			}
+			strs = append(strs, str)
+		}
+	default:
+		err = fmt.Errorf("expected a string or a list of strings, got %v", value)
+	}
+
+	return
+}
+
+/*
+main: Exatly what it says!! Main function. Runs the subcommand given on the command
+line, the webserver by default.
 */
 func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+/*
+serve: Starts the webserver and keeps departures up to date until it ends.
+*/
+func serve() {
 
 	// Read configuration information
 	getConfig()
+	checkServerConfig()
+	resolveStopConfig(true)
 
 	// Populate internal structures from Graphql response
-	for _, stop := range(configStopGtfsIds) {
-		routeInf := buildRouteData(stop)
-		routeInfo = append(routeInfo, routeInf)
+	departureData = newDepartureStore(configStopGtfsIds)
+	// Discovery fetches every stop it finds, configured ones included
+	discovered := homeConfigured && refreshNearbyStops()
+	if !discovered {
+		refreshRouteInfo()
 	}
 
+	// Keep them up to date in the background
+	startRefresher()
+	startStopDiscovery(discovered)
+
+	// Chat bot, if configured
+	startTelegramBot()
+
 	// Start the webserver
 	listenAndServe()
 
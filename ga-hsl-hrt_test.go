@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCallSignSpelling(t *testing.T) {
+	destinations := map[string]destinationSpec{
+		"sello":     {headsigns: []string{"Leppävaara"}},
+		"itis":      {headsigns: []string{"Itäkeskus"}},
+		"unspelled": {headsigns: []string{"Kamppi"}},
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"spelling from file", `{"callSignToHeadsign": {"Sello": "Leppävaara", "ITIS": "Itäkeskus"}}`,
+			[]string{"ITIS", "Sello", "unspelled"}},
+		{"key case ignored", `{"CallSignToHeadsign": {"Sello": "Leppävaara"}}`,
+			[]string{"Sello", "itis", "unspelled"}},
+		{"no call signs", `{"routes": ["215"]}`, []string{"itis", "sello", "unspelled"}},
+		{"malformed call signs", `{"callSignToHeadsign": ["Sello"]}`, []string{"itis", "sello", "unspelled"}},
+		{"malformed file", `{"callSignToHeadsign": {`, []string{"itis", "sello", "unspelled"}},
+		{"missing file", "", []string{"itis", "sello", "unspelled"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config-file.json")
+			if tt.config != "" {
+				if err := os.WriteFile(configFile, []byte(tt.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			spelled := callSignSpelling(destinations, configFile)
+
+			var got []string
+			for callSign, dest := range spelled {
+				got = append(got, callSign)
+				if want := destinations[strings.ToLower(callSign)]; !reflect.DeepEqual(dest, want) {
+					t.Errorf("%s maps to %v, want %v", callSign, dest, want)
+				}
+			}
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("call signs %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDestinations(t *testing.T) {
+	tests := []struct {
+		name    string
+		signs   string
+		want    map[string]destinationSpec
+		wantErr bool
+	}{
+		{"single headsign", `{"Sello": "Leppävaara"}`,
+			map[string]destinationSpec{"sello": {headsigns: []string{"Leppävaara"}}}, false},
+		{"list of headsigns", `{"Helsinki": ["Elielinaukio", "Kamppi"]}`,
+			map[string]destinationSpec{"helsinki": {headsigns: []string{"Elielinaukio", "Kamppi"}}}, false},
+		{"object with headsigns and stops", `{"Helsinki": {"headsigns": "Kamppi", "stops": ["HSL:1040601"]}}`,
+			map[string]destinationSpec{"helsinki": {headsigns: []string{"Kamppi"}, stops: []string{"HSL:1040601"}}}, false},
+		{"object with stops only", `{"Tapiola": {"stops": ["HSL:2222234", "HSL:2222235"]}}`,
+			map[string]destinationSpec{"tapiola": {stops: []string{"HSL:2222234", "HSL:2222235"}}}, false},
+		{"several call signs", `{"Sello": "Leppävaara", "Tapiola": {"stops": "HSL:2222234"}}`,
+			map[string]destinationSpec{
+				"sello":   {headsigns: []string{"Leppävaara"}},
+				"tapiola": {stops: []string{"HSL:2222234"}},
+			}, false},
+		{"non-string in list", `{"Sello": ["Leppävaara", 215]}`, nil, true},
+		{"non-string headsigns", `{"Sello": {"headsigns": [true]}}`, nil, true},
+		{"non-string stops", `{"Sello": {"stops": {"id": "HSL:1"}}}`, nil, true},
+		{"number", `{"Sello": 215}`, nil, true},
+		{"empty list", `{"Sello": []}`, nil, true},
+		{"empty object", `{"Sello": {}}`, nil, true},
+		{"object with empty lists", `{"Sello": {"headsigns": [], "stops": []}}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Through viper, like getConfig, for the same value types and key case
+			v := viper.New()
+			v.SetConfigType("json")
+			if err := v.ReadConfig(strings.NewReader(`{"callSignToHeadsign": ` + tt.signs + `}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseDestinations(v.GetStringMap(SIGNS))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDestinations() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDestinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
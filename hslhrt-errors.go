@@ -0,0 +1,94 @@
+/*
+hslhrt-errors.go
+
+Typed errors for failures when retrieving data from HSL API.
+- Callers can tell transient network problems from GraphQL errors, unknown stops
+  and responses that do not match the expected schema.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kind of a failure towards HSL API
+type fetchErrorKind int
+
+const (
+	networkError fetchErrorKind = iota
+	graphQLError
+	unknownStopError
+	schemaError
+)
+
+// Error returned when data of a stop could not be retrieved from HSL API
+type fetchError struct {
+	kind   fetchErrorKind
+	gtfsId string
+	err    error
+}
+
+func (kind fetchErrorKind) String() string {
+	switch kind {
+	case networkError:
+		return "network error"
+	case graphQLError:
+		return "graphql error"
+	case unknownStopError:
+		return "unknown stop"
+	case schemaError:
+		return "schema mismatch"
+	default:
+		return "unknown error"
+	}
+}
+
+func (fe *fetchError) Error() string {
+	if fe.err == nil {
+		return fmt.Sprintf("stop %s: %s", fe.gtfsId, fe.kind)
+	}
+
+	return fmt.Sprintf("stop %s: %s: %v", fe.gtfsId, fe.kind, fe.err)
+}
+
+func (fe *fetchError) Unwrap() error {
+	return fe.err
+}
+
+/*
+newFetchError: Helper function - Creates a fetch error of the given kind.
+*/
+func newFetchError(kind fetchErrorKind, gtfsId string, err error) error {
+	return &fetchError{kind: kind, gtfsId: gtfsId, err: err}
+}
+
+/*
+classifyRunError: Maps an error returned by the GraphQL client into a fetch error.
+The client reports the first entry of a GraphQL errors array as "graphql: <message>".
+*/
+func classifyRunError(gtfsId string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case strings.HasPrefix(err.Error(), "graphql: "):
+		return newFetchError(graphQLError, gtfsId, err)
+	case errors.As(err, &typeErr):
+		return newFetchError(schemaError, gtfsId, err)
+	default:
+		// Connection failures, timeouts and non JSON error pages from the API
+		return newFetchError(networkError, gtfsId, err)
+	}
+}
+
+/*
+isFetchError: Checks if err is a fetch error of the given kind.
+*/
+func isFetchError(err error, kind fetchErrorKind) bool {
+	var fe *fetchError
+
+	return errors.As(err, &fe) && fe.kind == kind
+}
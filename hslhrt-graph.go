@@ -11,23 +11,71 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"time"
+	_ "time/tzdata" // Europe/Helsinki must resolve even without a system tz database
 	"github.com/machinebox/graphql"
 	log "github.com/sirupsen/logrus"
-	"strings"
 	"sort"
 )
 
 // Universal GraphQL client variable
 var graphClient *graphql.Client = graphql.NewClient(url)
 
-// Sort structure and functions for scheduled arrival time
+// Maximum time to wait for HSL API to respond
+const graphTimeout = 15 * time.Second
+
+// Departures retrieved per stop. Enough for follow-up questions after the first answer,
+// and for stops where routes outside the allow-list leave frequently.
+const stoptimesPerStop = 30
+
+// Stops retrieved per stopsByRadius page, and the most pages followed
+const nearbyPageSize = 100
+const nearbyMaxPages = 10
+
+// HSL timetables are in Helsinki local time, regardless of where the server runs
+var hslLocation *time.Location = loadHslLocation()
+
+/*
+loadHslLocation: Loads the Europe/Helsinki time zone. Embedded tzdata guarantees it exists.
+*/
+func loadHslLocation() *time.Location {
+	loc, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		log.Panic("Unable to load Europe/Helsinki time zone: ", err)
+	}
+
+	return loc
+}
+
+/*
+serviceTime: Converts seconds since the start of a service day into an absolute time.
+Seconds may exceed 86400 for trips running past midnight, e.g. night buses.
+*/
+func serviceTime(serviceDay int64, seconds float64) time.Time {
+	return time.Unix(serviceDay+int64(seconds), 0).In(hslLocation)
+}
+
+/*
+swedishName: Helper function - Swedish name of the stop, empty if it has none. HSL API
+falls back to the Finnish name when asked for a Swedish name that does not exist.
+*/
+func (stop gqlStop) swedishName() string {
+	if stop.NameSv == stop.Name {
+		return ""
+	}
+
+	return stop.NameSv
+}
+
+// Sort structure and functions for scheduled departure time
 type aDSlice []routeArrDepDetails
 func (aD aDSlice) Len() int { 
 	return len(aD) 
 }
 
 func (aD aDSlice) Less(i, j int) bool { 
-	return aD[i].scheduledDeparture < aD[j].scheduledDeparture 
+	return aD[i].scheduledDepartureTime.Before(aD[j].scheduledDepartureTime)
 }
 
 func (aD aDSlice) Swap(i, j int) {
@@ -38,23 +86,22 @@ func (aD aDSlice) Swap(i, j int) {
 getRoutesFromStop: Retrieves routes from HSL API over the GraphQL interface
 Include the used GraphQL query that can also be verified at this link:
 https://api.digitransit.fi/graphiql/hsl. 
+The response is decoded straight into the gql* structures in types.go, so a new
+field only needs to be added to the query and to the matching structure.
 Input: gtfsId that uniquely identifies a bus stop
 Output: Returns the route data structure with arrival and departure times of routes
-from the bus stop.
+from the bus stop, or a fetch error describing why it could not be retrieved.
 */
-func getRoutesFromStop(gtfsId string) (routeInfo routeData) {
+func getRoutesFromStop(gtfsId string) (routeInfo routeData, err error) {
 
-	req := graphql.NewRequest(`query ($id: String!) {
+	req := graphql.NewRequest(`query ($id: String!, $departures: Int!) {
 		stop (id: $id) {
 			name
+			nameSv: name(language: "sv")
 			code
-			routes {
-			  shortName
-			  patterns{
-				headsign
-			  }
-			}
-			stoptimesWithoutPatterns {
+			lat
+			lon
+			stoptimesWithoutPatterns (numberOfDepartures: $departures) {
 				scheduledArrival
 		  		realtimeArrival
 		  		arrivalDelay
@@ -65,149 +112,99 @@ func getRoutesFromStop(gtfsId string) (routeInfo routeData) {
 		  		realtimeState
 		  		serviceDay
 				headsign
+				trip {
+					route {
+						shortName
+						gtfsId
+						mode
+					}
+					pattern {
+						code
+						directionId
+						stops {
+							gtfsId
+						}
+					}
+				}
 			}
 		}
 	}`)
 
 	req.Var("id", gtfsId)
-	ctx := context.Background()
+	req.Var("departures", stoptimesPerStop)
+	ctx, cancel := context.WithTimeout(context.Background(), graphTimeout)
+	defer cancel()
 
-	var respMap map[string]interface{}
+	var resp gqlStopResponse
 
-	if err := graphClient.Run(ctx, req, &respMap); err != nil {
-		log.Fatal(err)
+	if err = graphClient.Run(ctx, req, &resp); err != nil {
+		err = classifyRunError(gtfsId, err)
+		return
 	}
 
-	var stopDet stopStruct
-	var arrivalDeparture []routeArrDepDetails
-	var routeSigns []routeHeadSigns
-
-	stopDet.gtfsId = gtfsId
-
-	if stop := respMap["stop"]; stop != nil {
-		stopDetails := stop.(map[string]interface{})
-
-		for key, val := range stopDetails {
-			switch key {
-			case "name":
-				stopDet.name = val.(string)
-			case "lat":
-				stopDet.latitude = val.(float64)
-			case "lon":
-				stopDet.longitude = val.(float64)
-			case "code":
-				stopDet.code = val.(string)
-			case "stoptimesWithoutPatterns":
-				stopTimes := stopDetails["stoptimesWithoutPatterns"].([]interface{})
-				for _, stopAD := range stopTimes {
-					var arrDep routeArrDepDetails
-	
-					arDepTimes := stopAD.(map[string]interface{})
-	
-					// Scheduled arrival time
-					if item := arDepTimes["scheduledArrival"]; item != nil {
-						arrDep.scheduledArrival = item.(float64)
-					}
-	
-					// Realtime arrival time
-					if item := arDepTimes["realtimeArrival"]; item != nil {
-						arrDep.realtimeArrival = item.(float64)
-					}
-	
-					// Arrival delay time
-					if item := arDepTimes["arrivalDelay"]; item != nil {
-						arrDep.arrivalDelay = item.(float64)
-					}
-	
-					// Scheduled departure time
-					if item := arDepTimes["scheduledDeparture"]; item != nil {
-						arrDep.scheduledDeparture = item.(float64)
-					}
-	
-					// Real time departure time
-					if item := arDepTimes["realtimeDeparture"]; item != nil {
-						arrDep.realtimeDeparture = item.(float64)
-					}
-	
-					// Departure delay time
-					if item := arDepTimes["departureDelay"]; item != nil {
-						arrDep.departureDelay = item.(float64)
-					}
-	
-					// Realtime data available
-					if item := arDepTimes["realtime"]; item != nil {
-						arrDep.realtime = item.(bool)
-					}
-	
-					// Realtime state available
-					if item := arDepTimes["realtimeState"]; item != nil {
-						arrDep.realtimeState = item.(string)
-					}
-	
-					// Headsign
-					if item := arDepTimes["headsign"]; item != nil {
-						arrDep.headSign = item.(string)
-					}
-	
-					arrivalDeparture = append(arrivalDeparture, arrDep)
-				}
-			default:
-				// Do nothing
-			}
+	stop := resp.Stop
+	if stop == nil {
+		err = newFetchError(unknownStopError, gtfsId, nil)
+		return
+	}
+
+	routeInfo.stopDetails = stopStruct{
+		gtfsId:    gtfsId,
+		name:      stop.Name,
+		nameSv:    stop.swedishName(),
+		code:      stop.Code,
+		latitude:  stop.Lat,
+		longitude: stop.Lon,
+	}
+
+	for _, st := range stop.StoptimesWithoutPatterns {
+		arrDep := routeArrDepDetails{
+			scheduledArrival:   st.ScheduledArrival,
+			realtimeArrival:    st.RealtimeArrival,
+			arrivalDelay:       st.ArrivalDelay,
+			scheduledDeparture: st.ScheduledDeparture,
+			realtimeDeparture:  st.RealtimeDeparture,
+			departureDelay:     st.DepartureDelay,
+			realtime:           st.Realtime,
+			realtimeState:      st.RealtimeState,
+			headSign:           st.Headsign,
+
+			scheduledDepartureTime: serviceTime(st.ServiceDay, st.ScheduledDeparture),
+			realtimeDepartureTime:  serviceTime(st.ServiceDay, st.RealtimeDeparture),
 		}
 
-		// Sort routes based on scheduled arrival time
-		sort.Sort(aDSlice(arrivalDeparture))
-		routeInfo.stopDetails = stopDet
-		routeInfo.arrDepDetails = arrivalDeparture
-
-		// Now map the routes to the headsigns
-		// Headsign in the stop pattern is the destination of the route/bus
-		// Headsign in the routes struct contains both start and dest.
-		// So compare the headsign of the stop pattern with headsigns of the routes to find 
-		// the route for that particulartime in the stop pattern
-		// NOTE: Headsign Names in the routes are shorter than head sign names in the stop pattern
-
-		// Build the route signs structure
-		var routeSign routeHeadSigns
-		if routeNames := stopDetails["routes"]; routeNames != nil {
-			routeNames := routeNames.([]interface{})
-
-			for _, val := range routeNames {
-				patMap := val.(map[string]interface{})
-				if patterns := patMap["patterns"]; patterns != nil {
-					patterns := patMap["patterns"].([]interface{})
-
-					var signs []string
-					for _, val := range patterns {
-						hsMap := val.(map[string]interface{})
-						signs = append(signs, hsMap["headsign"].(string))
-					}
-					routeSign.headsigns = signs
-				}
-				routeSign.routeName = patMap["shortName"].(string)
-				routeSigns = append(routeSigns, routeSign)
-			}
-			log.Debug("routeSigns-", routeSigns)
+		// The trip tells authoritatively which line and direction the departure is
+		if st.Trip != nil {
+			arrDep.route = st.Trip.Route.ShortName
+			arrDep.routeGtfsId = st.Trip.Route.GtfsId
+			arrDep.mode = st.Trip.Route.Mode
+			arrDep.patternCode = st.Trip.Pattern.Code
+			arrDep.directionId = st.Trip.Pattern.DirectionId
+			arrDep.downstreamStops = stopsAfter(gtfsId, st.Trip.Pattern.Stops)
+		} else {
+			log.Debug("No trip for departure from stop - ", gtfsId, " to ", st.Headsign)
 		}
 
-		// Compare routes and arrivalDep details to match destination headsigns
-		for indx, arrDep := range routeInfo.arrDepDetails {
-			found := false
-			for _, rt := range routeSigns {
-				for _, hs := range rt.headsigns {
-					if strings.Contains(strings.ToLower(arrDep.headSign), strings.ToLower(hs)) {
-						routeInfo.arrDepDetails[indx].route = rt.routeName
-						found = true
-						break
-					}
-				}
+		routeInfo.arrDepDetails = append(routeInfo.arrDepDetails, arrDep)
+	}
 
-				if found {
-					break
-				}
-			}
-			log.Debug("arrDep - ", arrDep)
+	// Sort routes based on scheduled arrival time
+	sort.Sort(aDSlice(routeInfo.arrDepDetails))
+
+	return
+}
+
+/*
+stopsAfter: Returns the stops a pattern visits after the given stop.
+*/
+func stopsAfter(gtfsId string, patternStops []gqlPatternStop) (after []string) {
+	passed := false
+
+	for _, stop := range patternStops {
+		if passed {
+			after = append(after, stop.GtfsId)
+		} else if stop.GtfsId == gtfsId {
+			passed = true
 		}
 	}
 
@@ -219,13 +216,179 @@ buildRouteData: Function that builds route information for a stop configured in
 configuration file.
 Input: gtfsId that uniquely identifies a bus stop
 Output: Returns the route data structure with arrival and departure times of routes
-from the bus stop.
+from the bus stop, or an error if HSL API could not provide them.
 */
-func buildRouteData(gtfsId string) (routeInfo routeData) {
+func buildRouteData(gtfsId string) (routeInfo routeData, err error) {
 
-	routeInfo = getRoutesFromStop(gtfsId)
+	routeInfo, err = getRoutesFromStop(gtfsId)
+	if err != nil {
+		return
+	}
 
 	log.Info("Route Info - ", routeInfo)
 
 	return
 }
+
+/*
+searchStops: Searches HSL stops by name or stop code, e.g. "Jupperinympyrä" or "E1439".
+Input: the name or code to search for
+Output: Returns the matching stops without departures, or a fetch error describing why
+they could not be retrieved.
+*/
+func searchStops(name string) (stops []stopStruct, err error) {
+
+	req := graphql.NewRequest(`
+	query ($name: String!) {
+		stops (name: $name) {
+			gtfsId
+			name
+			nameSv: name(language: "sv")
+			code
+			platformCode
+			lat
+			lon
+		}
+	}`)
+
+	req.Var("name", name)
+	ctx, cancel := context.WithTimeout(context.Background(), graphTimeout)
+	defer cancel()
+
+	var resp gqlStopsResponse
+
+	if err = graphClient.Run(ctx, req, &resp); err != nil {
+		err = classifyRunError(name, err)
+		return
+	}
+
+	for _, stop := range resp.Stops {
+		stops = append(stops, stopStruct{
+			gtfsId:    stop.GtfsId,
+			name:      stop.Name,
+			nameSv:    stop.swedishName(),
+			code:      stop.Code,
+			platform:  stop.PlatformCode,
+			latitude:  stop.Lat,
+			longitude: stop.Lon,
+		})
+	}
+
+	return
+}
+
+/*
+getStopsByRadius: Retrieves the stops within the radius of a coordinate, nearest first,
+with the lines serving them. Pages of the stopsByRadius connection are followed up to
+nearbyMaxPages, stops beyond that are left out with a warning.
+Input: latitude and longitude of the coordinate, radius in meters
+Output: Returns the nearby stops, or a fetch error describing why they could not be
+retrieved.
+*/
+func getStopsByRadius(latitude float64, longitude float64, radius int) (stops []nearbyStop, err error) {
+
+	var after string
+	for page := 1; ; page++ {
+		var conn gqlStopAtDistanceConnection
+		if conn, err = getStopsByRadiusPage(latitude, longitude, radius, after); err != nil {
+			return
+		}
+
+		for _, edge := range conn.Edges {
+			stops = append(stops, edge.Node.nearbyStop())
+		}
+
+		if !conn.PageInfo.HasNextPage || conn.PageInfo.EndCursor == "" {
+			return
+		}
+
+		if page == nearbyMaxPages {
+			log.Warn("More than ", len(stops), " stops within ", radius, " m, the farthest ones are left out")
+			return
+		}
+
+		after = conn.PageInfo.EndCursor
+	}
+}
+
+/*
+getStopsByRadiusPage: Retrieves a page of stopsByRadius after the given cursor, the
+first page with an empty cursor.
+*/
+func getStopsByRadiusPage(latitude float64, longitude float64, radius int, after string) (conn gqlStopAtDistanceConnection, err error) {
+
+	req := graphql.NewRequest(`
+	query ($lat: Float!, $lon: Float!, $radius: Int!, $first: Int!, $after: String) {
+		stopsByRadius (lat: $lat, lon: $lon, radius: $radius, first: $first, after: $after) {
+			edges {
+				node {
+					distance
+					stop {
+						gtfsId
+						name
+						nameSv: name(language: "sv")
+						code
+						platformCode
+						lat
+						lon
+						routes {
+							shortName
+							mode
+						}
+					}
+				}
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`)
+
+	req.Var("lat", latitude)
+	req.Var("lon", longitude)
+	req.Var("radius", radius)
+	req.Var("first", nearbyPageSize)
+	if after != "" {
+		req.Var("after", after)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), graphTimeout)
+	defer cancel()
+
+	var resp gqlStopsByRadiusResponse
+
+	where := fmt.Sprintf("%.5f,%.5f", latitude, longitude)
+	if err = graphClient.Run(ctx, req, &resp); err != nil {
+		err = classifyRunError(where, err)
+		return
+	}
+
+	return resp.StopsByRadius, nil
+}
+
+/*
+nearbyStop: Helper function - Converts a stop at a distance into a nearby stop with
+its lines and their modes.
+*/
+func (node gqlStopAtDistance) nearbyStop() nearbyStop {
+	stop := node.Stop
+	nearby := nearbyStop{
+		stop: stopStruct{
+			gtfsId:    stop.GtfsId,
+			name:      stop.Name,
+			nameSv:    stop.swedishName(),
+			code:      stop.Code,
+			platform:  stop.PlatformCode,
+			latitude:  stop.Lat,
+			longitude: stop.Lon,
+		},
+		distance: node.Distance,
+	}
+
+	for _, route := range stop.Routes {
+		nearby.routes = append(nearby.routes, route.ShortName)
+		nearby.modes = append(nearby.modes, route.Mode)
+	}
+
+	return nearby
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/machinebox/graphql"
+)
+
+// fakeStopsByRadius serves stopsByRadius pages of a single stop each, total stops in all.
+func fakeStopsByRadius(t *testing.T, total int) (requests *int) {
+	requests = new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+
+		page := *requests
+		*requests++
+		if after, _ := req.Variables["after"].(string); page > 0 && after != fmt.Sprint("cursor-", page) {
+			t.Errorf("page %d requested after %q", page+1, after)
+		}
+
+		fmt.Fprintf(w, `{"data": {"stopsByRadius": {
+			"edges": [{"node": {"distance": %d, "stop": {"gtfsId": "HSL:%d", "routes": [{"shortName": "215", "mode": "BUS"}]}}}],
+			"pageInfo": {"hasNextPage": %v, "endCursor": "cursor-%d"}}}}`, page*10, page+1, page+1 < total, page+1)
+	}))
+
+	client := graphClient
+	graphClient = graphql.NewClient(server.URL)
+	t.Cleanup(func() {
+		graphClient = client
+		server.Close()
+	})
+
+	return
+}
+
+func TestGetStopsByRadiusPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int
+		want     int
+		requests int
+	}{
+		{"single page", 1, 1, 1},
+		{"several pages", 3, 3, 3},
+		{"pages beyond the last followed", nearbyMaxPages + 2, nearbyMaxPages, nearbyMaxPages},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := fakeStopsByRadius(t, tt.total)
+
+			stops, err := getStopsByRadius(60.17, 24.94, 500)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(stops) != tt.want || *requests != tt.requests {
+				t.Errorf("got %d stops in %d requests, want %d in %d", len(stops), *requests, tt.want, tt.requests)
+			}
+			for i, stop := range stops {
+				if want := fmt.Sprint("HSL:", i+1); stop.stop.gtfsId != want || stop.distance != i*10 {
+					t.Errorf("stop %d = %s at %d m, want %s at %d m", i, stop.stop.gtfsId, stop.distance, want, i*10)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceTime(t *testing.T) {
+	// HSL service days start 12 hours before noon, which is not midnight on DST changes
+	serviceDay := func(year int, month time.Month, day int) int64 {
+		return time.Date(year, month, day, 12, 0, 0, 0, hslLocation).Add(-12 * time.Hour).Unix()
+	}
+	clock := func(hours, minutes int) float64 {
+		return float64(hours*3600 + minutes*60)
+	}
+
+	tests := []struct {
+		name       string
+		serviceDay int64
+		seconds    float64
+		want       string
+	}{
+		{"afternoon", serviceDay(2020, 3, 2), clock(15, 4), "2020-03-02 15:04 +0200"},
+		{"night bus after midnight", serviceDay(2020, 3, 2), clock(25, 30), "2020-03-03 01:30 +0200"},
+		{"night bus past a day", serviceDay(2020, 3, 2), 86400 + 59, "2020-03-03 00:00 +0200"},
+		{"summer time", serviceDay(2020, 7, 1), clock(8, 15), "2020-07-01 08:15 +0300"},
+		{"DST starts, before the change", serviceDay(2020, 3, 29), clock(1, 0), "2020-03-29 00:00 +0200"},
+		{"DST starts, noon", serviceDay(2020, 3, 29), clock(12, 0), "2020-03-29 12:00 +0300"},
+		{"DST starts, night bus", serviceDay(2020, 3, 29), clock(26, 0), "2020-03-30 02:00 +0300"},
+		{"DST ends, noon", serviceDay(2020, 10, 25), clock(12, 0), "2020-10-25 12:00 +0200"},
+		{"DST ends, evening", serviceDay(2020, 10, 25), clock(23, 0), "2020-10-25 23:00 +0200"},
+	}
+
+	hosts := []string{"UTC", "America/New_York", "Asia/Tokyo"}
+
+	for _, host := range hosts {
+		local, err := time.LoadLocation(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(host+"/"+tt.name, func(t *testing.T) {
+				saved := time.Local
+				time.Local = local
+				t.Cleanup(func() { time.Local = saved })
+
+				got := serviceTime(tt.serviceDay, tt.seconds)
+				if got.Location() != hslLocation {
+					t.Errorf("location = %v, want %v", got.Location(), hslLocation)
+				}
+				if formatted := got.Format("2006-01-02 15:04 -0700"); formatted != tt.want {
+					t.Errorf("serviceTime() = %s, want %s", formatted, tt.want)
+				}
+			})
+		}
+	}
+}
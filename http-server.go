@@ -10,11 +10,10 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"strings"
+	"sort"
 	"time"
 	"io/ioutil"
 	"crypto/tls"
@@ -42,19 +41,20 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 }
 
 /*
-timeFromSeconds: Helper function - To convert time from float64 to locale based 
-formatted string.
+requireClientCert: Middleware - Only lets requests with a verified client certificate
+through. Google and REST API clients present one, Alexa authenticates by request
+signatures instead.
 */
-func timeFromSeconds(seconds float64) (calcTime time.Time) {
-
-	currTime := time.Now()
-
-	loc, _ := time.LoadLocation("Local")
-
-	calcTime = time.Date(currTime.Year(), currTime.Month(), currTime.Day(), 0, 0, int(seconds), 0, loc)
-
-	return
+func requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			log.Error("Request without client certificate to - ", r.URL.Path)
+			respondWithError(w, http.StatusUnauthorized)
+			return
+		}
 
+		next(w, r)
+	}
 }
 
 /*
@@ -67,7 +67,18 @@ This also means runtime configuration updates have to be supported first :)
 func listenAndServe() {
 
 	r := mux.NewRouter()
-	r.HandleFunc("/getRoute", GetRouteHandler).Methods("POST")
+	r.HandleFunc("/getRoute", requireClientCert(GetRouteHandler)).Methods("POST")
+	r.HandleFunc("/cx/getRoute", requireClientCert(GetCxRouteHandler)).Methods("POST")
+
+	// REST API, for dashboards and scripts with a client certificate
+	r.HandleFunc("/api/v1/departures", requireClientCert(GetDeparturesAPIHandler)).Methods("GET")
+	r.HandleFunc("/api/v1/stops", requireClientCert(GetStopsAPIHandler)).Methods("GET")
+	r.HandleFunc("/api/v1/destinations", requireClientCert(GetDestinationsAPIHandler)).Methods("GET")
+
+	// Alexa skill is enabled by configuring its skill id
+	if alexaSkillId != "" {
+		r.HandleFunc("/alexa", GetAlexaHandler).Methods("POST")
+	}
 
 	// Read google client certificates for mTLS
 	// curl https://pki.goog/gsr2/GTS1O1.crt | openssl x509 -inform der >> google-certs\ca-cert.pem
@@ -84,10 +95,16 @@ func listenAndServe() {
 	caCertPool := x509.NewCertPool()
 	caCertPool.AppendCertsFromPEM(caCerts)
 
-	// Create the TLS Config with the CA pool and enable Client certificate validation
+	// Create the TLS Config with the CA pool and enable Client certificate validation.
+	// Alexa does not send one, with its skill enabled certificates are required per route.
+	clientAuth := tls.RequireAndVerifyClientCert
+	if alexaSkillId != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
 	tlsConfig := &tls.Config{
 		ClientCAs: caCertPool,
-		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientAuth: clientAuth,
 	}
 	tlsConfig.BuildNameToCertificate()	
 
@@ -113,136 +130,65 @@ func listenAndServe() {
 }
 
 /*
-extractPostParams: Extracts JSON body parameters from Webhook Request received from
-Dialogflow.
+extractPostParams: Decodes the Webhook Request received from Dialogflow. Parameters
+are read by the handler of the intent, according to its parameter schema.
 */
-func extractPostParams (r *http.Request) (request string, route string, destination string) {
+func extractPostParams (r *http.Request) (webHookReq dfWebhookRequest) {
 
 	defer r.Body.Close()
 
-	decoder := json.NewDecoder(r.Body)
-	var t map[string]interface{}
-	err := decoder.Decode(&t)
+	err := json.NewDecoder(r.Body).Decode(&webHookReq)
 
 	if err != nil {
 		log.Error("Error decoding json body in request - ", err)
-		return
 	}
 
 	// We are trying to extract this part of json from the POST body
 	// "queryResult":{"queryText":"route 215",
 	// 		"parameters":{"route":[54,8],"place-attraction":"tapiola"},......"intent":{"name":"...","displayName":"Bus-Destination"},
 	//		"parameters":{"place-attraction":"sello"},......"intent":{"name":"...","displayName":"Destination-Only"},
+	return
+}
 
-	_, ok := t["queryResult"]
-	if ok {
-		qResult := t["queryResult"].(map[string]interface{})
-
-		// Check intent type and extract parameters
-		_, ok:= qResult["intent"]
-
-		if ok {
-			intent := qResult["intent"].(map[string]interface{})
-
-			_, ok := intent["displayName"]
-
-			if ok {
-				rcvdIntent := intent["displayName"].(string)
-
-				switch strings.ToLower(rcvdIntent) {
-				case strings.ToLower(DESTONLY):
-					request = DESTONLY
-				case strings.ToLower(BUSDEST):
-					request = BUSDEST
-				default:
-					log.Error("Unsupported intent received-", rcvdIntent)
-				} 
-			}
-		}
-
-		_, ok = qResult["parameters"]
-
-		if ok {
-			parameters := qResult["parameters"].(map[string]interface{})
-
-			// Extract route parameter which is available only if the intent is bus-dest
-			if request == BUSDEST {
-				_, ok := parameters["route"]
-
-				// route is a list which can be [2,1,4] or [21,4] or [2,14] or [214]
-				// Best option is to convert each number to string and concatenate them
-	
-				if ok {
-					routeList := parameters["route"].([]interface{})
-					for i:=0;i<len(routeList);i++ {
-						st := fmt.Sprintf("%.0f", routeList[i].(float64))
-						route = route + st
-					}
-	
-					// Intelligent??: Take only first 3 numbers to ignore user conversational errors
-					if len(route) > 3 {
-						route = route[:3]
-					}	
-				} else {
-					log.Debug("parameters[route] - ", parameters["route"])
-					return
-				}
-			}
-
-			// Extract destination
-			_, ok := parameters["place-attraction"]
-			if ok {
-				destination = parameters["place-attraction"].(string)
-			} else {
-				log.Debug("parameters[place-attraction] - ", parameters["place-attraction"])
-			}
-				
-		} else {
-			log.Debug("qResult[parameters] - ", qResult["parameters"])
-			return
+/*
+formatDeparture: Helper function - Phrases a departure time for speech. Imminent
+departures are relative ("now", "in 4 minutes"), later ones absolute ("at 15:04").
+*/
+func formatDeparture(departure time.Time, now time.Time, lang string) spokenText {
+	wait := departure.Sub(now)
+
+	switch {
+	case wait < time.Minute:
+		// Includes buses that are at the stop within the grace period
+		return plainSpeech(message(lang, msgNow))
+	case wait < relativeTimeWindow:
+		minutes := int(wait / time.Minute)
+		if minutes == 1 {
+			return plainSpeech(message(lang, msgInOneMinute))
 		}
-	} else {
-		log.Debug("t[queryResult] - ", t["queryResult"])
-		return
+		return plainSpeech(message(lang, msgInMinutes, minutes))
+	default:
+		return plainSpeech(message(lang, msgAt)).sayClock(departure, lang)
 	}
-
-	return
 }
 
 /*
 GetBusDestinationHandler: Handler to extract bus and destination details from route structurees,
-based on given bus and destination.
-Formats them into a string slice with scheduled/realtime departure timings
+based on given departures of a bus towards a destination.
+Formats them into speech in the given language with scheduled/realtime departure timings
 */
-func GetBusDestinationHandler(route string, headSign string) (routes []string){
-	var routeString string
-	for _, rtInfo := range routeInfo {
-		found := false
-		routeString = "Leaves from " + 
-			rtInfo.stopDetails.name + 
-			" (" + rtInfo.stopDetails.code + ")" + 
-			" at "
-		for _, arrDep := range rtInfo.arrDepDetails {
-			if strings.Contains(arrDep.route, route) {
-				if strings.Contains(strings.ToLower(arrDep.headSign), strings.ToLower(headSign)) {
-					// Bingo!
-					if found {
-						routeString = routeString + ", "
-					}
-
-					found = true
-					if arrDep.realtime {
-						routeString = routeString + timeFromSeconds(arrDep.realtimeDeparture).Format("15:04")
-					} else {
-						routeString = routeString + timeFromSeconds(arrDep.scheduledDeparture).Format("15:04")
-					}
-				}
-			}
+func GetBusDestinationHandler(matches []stopDepartures, now time.Time, lang string) (routes []spokenText){
+	for _, stopDeps := range matches {
+		var times []spokenText
+		for _, arrDep := range stopDeps.departures {
+			times = append(times, formatDeparture(arrDep.departureTime(), now, lang))
 		}
 
-		if found {
-			routes = append(routes, routeString)
-		}
+		routeString := plainSpeech(message(lang, msgLeavesFrom)).
+			sayStopName(stopDeps.stop, lang).
+			say(" (").sayStopCode(stopDeps.stop.code).say(") ")
+
+		routes = append(routes, routeString.add(joinSpeech(times, ", ")))
 	}
 
 	return
@@ -250,163 +196,152 @@ func GetBusDestinationHandler(route string, headSign string) (routes []string){
 
 /*
 GetDestinationHandler: Handler to extract bus and destination details from route structures,
-based on given destination.
-Formats them into a string slice with scheduled/realtime departure timings
+based on given departures towards a destination. A destination may span several headsigns and stops, their
+departures are merged per bus and ranked by the first departure of each bus.
+Formats them into speech in the given language with scheduled/realtime departure timings
 */
-func GetDestinationHandler(headSign string) (routes []string) {
+func GetDestinationHandler(matches []stopDepartures, now time.Time, lang string) (routes []spokenText) {
 	// Populate the GA Webhook Response Struct
 	var buses []string
-	var times []float64
-	for _, rtInfo := range routeInfo {
-		for _, arrDep := range rtInfo.arrDepDetails {
-			if strings.Contains(strings.ToLower(arrDep.headSign), strings.ToLower(headSign)) {
-				found := false
-				for indx, bus := range buses {
-					if strings.Contains(arrDep.route, bus) {
-						found = true
-						// We already have found this bus before for this dest, but now we have a new time. Append it
-						if arrDep.realtime {
-							routes[indx] = routes[indx] + "," + timeFromSeconds(arrDep.realtimeDeparture).Format("15:04")
-						} else {
-							routes[indx] = routes[indx] + "," + timeFromSeconds(arrDep.scheduledDeparture).Format("15:04")
-						}	
-						break
-					}	
-				}
-
-				if !found {
-					// New bus found for the given destination. Create an entry in routes.
-					buses = append(buses, arrDep.route)
-					routeString := "Bus " + arrDep.route + 
-						" leaves from " + 
-						rtInfo.stopDetails.name + 
-						" (" + rtInfo.stopDetails.code + ")" + 
-						" at "
-					var fTime float64
-					if arrDep.realtime {
-						routeString = routeString + timeFromSeconds(arrDep.realtimeDeparture).Format("15:04")
-						fTime = arrDep.realtimeDeparture
-					} else {
-						routeString = routeString + timeFromSeconds(arrDep.scheduledDeparture).Format("15:04")
-						fTime = arrDep.scheduledDeparture
-					}
-
-					times = append(times, fTime) 
-					routes = append(routes, routeString)	
-				}
+	var times []time.Time
+	for _, stopDeps := range matches {
+		for _, arrDep := range stopDeps.departures {
+			found := false
+			for indx, bus := range buses {
+				if arrDep.route == bus {
+					found = true
+					// We already have found this bus before for this dest, but now we have a new time. Append it
+					routes[indx] = routes[indx].pause(", ").add(formatDeparture(arrDep.departureTime(), now, lang))
+					break
+				}	
+			}
+
+			if !found {
+				// New bus found for the given destination. Create an entry in routes.
+				buses = append(buses, arrDep.route)
+				routeString := plainSpeech(message(lang, msgBusLeavesFrom, arrDep.route)).
+					sayStopName(stopDeps.stop, lang).
+					say(" (").sayStopCode(stopDeps.stop.code).say(") ").
+					add(formatDeparture(arrDep.departureTime(), now, lang))
+
+				times = append(times, arrDep.departureTime()) 
+				routes = append(routes, routeString)	
 			}
 		}
 	}
 
-	// Sort the routes based on times
+	// Rank the routes based on their first departure
 	log.Debug("Times - ", times)
 	log.Debug("Routes - ", routes)
-	for i:=0;i<len(times)-1;i++ {
-		for j:=0;j<(len(times)-i-1);j++ {
-			if times[j] > times[i] {
-				// swap routes
-				routes[i], routes[j] = routes[j], routes[i]
-				times[i], times[j] = times[j], times[i]
-			}
-		}
-	}
+	sort.Sort(rankedRoutes{routes: routes, times: times})
 	log.Debug("Aft Times - ", times)
 	log.Debug("Aft Routes - ", routes)
 
 	return
 }
 
+// Sort structure and functions for ranking routes by departure time
+type rankedRoutes struct {
+	routes []spokenText
+	times  []time.Time
+}
+
+func (rr rankedRoutes) Len() int {
+	return len(rr.routes)
+}
+
+func (rr rankedRoutes) Less(i, j int) bool {
+	return rr.times[i].Before(rr.times[j])
+}
+
+func (rr rankedRoutes) Swap(i, j int) {
+	rr.routes[i], rr.routes[j] = rr.routes[j], rr.routes[i]
+	rr.times[i], rr.times[j] = rr.times[j], rr.times[i]
+}
+
 /*
-GetRouteHandler: Webser main handler that invokes other functions based on available 
-bus+destination or destination.
-Formats the string slice into Webhook Response format for Dialogflow.
+respondWithWebHookError: Helper function - Responds to Dialogflow with a single
+spoken error message and keeps the conversation open for a retry.
 */
-func GetRouteHandler(w http.ResponseWriter, r *http.Request) {
+func respondWithWebHookError(w http.ResponseWriter, fulfillmentText string, speech spokenText) {
+	gaWebHkResp := webHookSpeechResponse(fulfillmentText, speech)
 
-	request, route, destination := extractPostParams(r)
-	log.Info("WebHook Req for request - ", request, " route - ", route, " to destination - ", destination)
+	log.Error("WebHook ERROR RESP - ", gaWebHkResp)
+	respondWithJSON(w, http.StatusOK, gaWebHkResp)
+}
 
-	var gaWebHkResp gaWebHookResponse
-	var items []itemStruct	
+/*
+respondWithWebHookPrompt: Helper function - Responds to Dialogflow with a single
+spoken question and keeps the conversation open for the answer, along with the
+given output contexts.
+*/
+func respondWithWebHookPrompt(w http.ResponseWriter, fulfillmentText string, speech spokenText, contexts ...dfContext) {
+	gaWebHkResp := webHookSpeechResponse(fulfillmentText, speech)
+	gaWebHkResp.OutputContexts = contexts
 
-	headSign, ok := configSigns[strings.ToLower(destination)]
+	log.Info("WebHook PROMPT RESP - ", gaWebHkResp)
+	respondWithJSON(w, http.StatusOK, gaWebHkResp)
+}
 
-	if !ok {
-		log.Error("Destination could not be mapped - ", destination)
-		gaWebHkResp.FulfillmentText = "Destination could not be mapped"
-	
-		item := itemStruct{
-			SimpleResponse: simpleRespStruct{
-				TextToSpeech: "Sorry, but destination could not be mapped! Please retry.",
+/*
+webHookSpeechResponse: Helper function - Builds a Webhook Response with a single
+simple response that expects the user to respond.
+*/
+func webHookSpeechResponse(fulfillmentText string, speech spokenText) (gaWebHkResp dfWebhookResponse) {
+	gaWebHkResp.FulfillmentText = fulfillmentText
+
+	items := []itemStruct{
+		{
+			SimpleResponse: &simpleRespStruct{
+				Ssml:        speech.speak(),
+				DisplayText: speech.text,
 			},
-		}
-
-		items = append(items, item)
+		},
+	}
 
-		richResp := richResponseStruct{
-			Items: items,
-		}
-	
-		gaWebHkResp.Payload = payloadStruct{
-			Google: googleStruct{
-				ExpectUserResponse: true,
-				RichResponse: richResp,
+	gaWebHkResp.Payload = &payloadStruct{
+		Google: googleStruct{
+			ExpectUserResponse: true,
+			RichResponse: richResponseStruct{
+				Items: items,
 			},
-		}
-	
-		log.Error("WebHook ERROR RESP - ", gaWebHkResp)
-		respondWithJSON(w, http.StatusOK, gaWebHkResp)
-		return
+		},
 	}
 
-	var routes []string
+	return
+}
 
-	switch request {
-	case BUSDEST: 
-		routes = GetBusDestinationHandler(route, headSign)
-	case DESTONLY:
-		routes = GetDestinationHandler(headSign)
-	default:
-		log.Error("Unsupported handler type - ", request, "Internal error!!")
-	}
+/*
+GetRouteHandler: Webser main handler that dispatches the request to the handler
+registered for its intent. Follow-up questions continue from the query kept
+in the follow-up context.
+Formats the answer into Webhook Response format for Dialogflow.
+*/
+func GetRouteHandler(w http.ResponseWriter, r *http.Request) {
 
-	if len(routes) == 0 {
-		log.Error("Route-", route, " Destination-", destination, " mismatch")
-		gaWebHkResp.FulfillmentText = "No routes to provided destination"
-	
-		item := itemStruct{
-			SimpleResponse: simpleRespStruct{
-				TextToSpeech: "Sorry, but no routes were found! Please retry.",
-			},
-		}
+	webHookReq := extractPostParams(r)
+	log.Info("WebHook Req for intent - ", webHookReq.QueryResult.Intent.DisplayName)
 
-		items = append(items, item)
+	answer := dispatchIntent(webHookReq.QueryResult.Intent.DisplayName, webHookReq.queryParam,
+		replyLanguage(webHookReq.QueryResult.LanguageCode), webHookReq.previousQuery())
 
-		richResp := richResponseStruct{
-			Items: items,
-		}
-	
-		gaWebHkResp.Payload = payloadStruct{
-			Google: googleStruct{
-				ExpectUserResponse: true,
-				RichResponse: richResp,
-			},
-		}
-	
-		log.Error("WebHook ERROR RESP - ", gaWebHkResp)
-		respondWithJSON(w, http.StatusOK, gaWebHkResp)
+	switch answer.status {
+	case answerError:
+		respondWithWebHookError(w, answer.fulfillmentText, answer.speech[0])
+		return
+	case answerPrompt:
+		respondWithWebHookPrompt(w, answer.fulfillmentText, answer.speech[0], webHookReq.followupContexts(answer)...)
 		return
 	}
 
-	// Only two simple responses are expected
-	if len(routes) > 2 {
-		routes = routes[:2]
-	}
-	
-	for _, rt := range routes {
+	var gaWebHkResp dfWebhookResponse
+	var items []itemStruct	
+
+	for _, rt := range answer.speech {
 		item := itemStruct{
-			SimpleResponse: simpleRespStruct{
-				TextToSpeech: rt,
+			SimpleResponse: &simpleRespStruct{
+				Ssml:        rt.speak(),
+				DisplayText: rt.text,
 			},
 		}
 	
@@ -416,10 +351,17 @@ func GetRouteHandler(w http.ResponseWriter, r *http.Request) {
 	richResp := richResponseStruct{
 		Items: items,
 	}
+
+	// Cards and chips for devices with a screen
+	if hasScreen(webHookReq) {
+		richResp.Items = append(richResp.Items, richItems(answer)...)
+		richResp.Suggestions = callSignSuggestions()
+	}
 	
-	gaWebHkResp.FulfillmentText = "Here are upcoming buses for route " + route
+	gaWebHkResp.FulfillmentText = answer.fulfillmentText
+	gaWebHkResp.OutputContexts = webHookReq.followupContexts(answer)
 	
-	gaWebHkResp.Payload = payloadStruct{
+	gaWebHkResp.Payload = &payloadStruct{
 		Google: googleStruct{
 			ExpectUserResponse: true,
 			RichResponse: richResp,
@@ -431,4 +373,4 @@ func GetRouteHandler(w http.ResponseWriter, r *http.Request) {
 
 	return
 
-}
\ No newline at end of file
+}
@@ -0,0 +1,139 @@
+/*
+intents.go
+
+Registry of the intents the webhooks can answer.
+- Every intent implements intentHandler: its name, the parameters it reads and a
+  handle function that answers it independent of the webhook format.
+- Intents register themselves from their own file, so new intents need no changes
+  to the webhooks.
+- Requests for unknown intents get a fallback reply telling what can be asked.
+*/
+
+package main
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How a parameter is read from the request
+type paramKind int
+
+const (
+	// Plain text, e.g. a destination
+	paramText paramKind = iota
+	// A route, which can be a list of numbers such as [2,1,4] or [21,4], or text such
+	// as "231N". It is flattened into a single string.
+	paramRoute
+)
+
+// A parameter an intent reads from the request
+type intentParam struct {
+	name string
+	kind paramKind
+}
+
+// A request for an intent, with the parameters of its schema. The previous query is
+// the one kept in the conversation, for follow-up questions.
+type intentRequest struct {
+	intent   string
+	params   map[string]string
+	language string
+	previous routeQuery
+}
+
+// Handler of an intent. Name matches the Dialogflow ES intent display name and the
+// Dialogflow CX fulfillment tag, case insensitively.
+type intentHandler interface {
+	name() string
+	parameters() []intentParam
+	handle(req intentRequest) routeAnswer
+}
+
+// Reads a parameter of a webhook request by name
+type paramLookup func(name string) (interface{}, bool)
+
+// Registered intents, keyed by lower case name
+var intentRegistry = make(map[string]intentHandler)
+
+/*
+registerIntent: Adds an intent handler to the registry. Registering the same name
+twice is a programming error.
+*/
+func registerIntent(handler intentHandler) {
+	key := strings.ToLower(handler.name())
+	if _, ok := intentRegistry[key]; ok {
+		log.Panic("Intent registered twice - ", handler.name())
+	}
+
+	intentRegistry[key] = handler
+}
+
+/*
+lookupIntent: Returns the handler of the intent with the given name.
+*/
+func lookupIntent(name string) (handler intentHandler, ok bool) {
+	handler, ok = intentRegistry[strings.ToLower(name)]
+
+	return
+}
+
+/*
+extractParams: Helper function - Reads the parameters of the schema from the request.
+Missing parameters are left out.
+*/
+func extractParams(schema []intentParam, lookup paramLookup) map[string]string {
+	params := make(map[string]string)
+
+	for _, param := range schema {
+		value, ok := lookup(param.name)
+		if !ok || value == nil {
+			log.Debug("parameters[", param.name, "] missing")
+			continue
+		}
+
+		switch param.kind {
+		case paramRoute:
+			params[param.name] = spokenRoute(value)
+		default:
+			if text, ok := value.(string); ok {
+				params[param.name] = text
+			} else {
+				log.Debug("parameters[", param.name, "] - ", value)
+			}
+		}
+	}
+
+	return params
+}
+
+/*
+dispatchIntent: Answers a webhook request with the handler registered for the intent,
+or with the fallback reply if there is none.
+*/
+func dispatchIntent(intent string, lookup paramLookup, lang string, previous routeQuery) routeAnswer {
+	handler, ok := lookupIntent(intent)
+	if !ok {
+		log.Error("Unsupported intent received-", intent)
+		return fallbackAnswer(lang)
+	}
+
+	req := intentRequest{
+		intent:   handler.name(),
+		params:   extractParams(handler.parameters(), lookup),
+		language: lang,
+		previous: previous,
+	}
+	log.Info("Intent - ", req.intent, " parameters - ", req.params)
+
+	return handler.handle(req)
+}
+
+/*
+fallbackAnswer: Helper function - Reply to intents this webhook does not know, telling
+the user what can be asked instead.
+*/
+func fallbackAnswer(lang string) routeAnswer {
+	return promptAnswer(lang, message(lang, msgUnknownIntentText), message(lang, msgUnknownIntent))
+}
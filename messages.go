@@ -0,0 +1,277 @@
+/*
+messages.go
+
+Message catalogue for replies in English, Finnish and Swedish.
+- The language is picked from the languageCode of the webhook request, e.g. "fi-FI".
+  Unsupported languages get English replies.
+- Times are written in the local convention of the language.
+- Stop names are given in Swedish when replying in Swedish and HSL has a Swedish name.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported reply languages
+const (
+	langEnglish string = "en"
+	langFinnish string = "fi"
+	langSwedish string = "sv"
+)
+
+// Keys of the message catalogue
+type messageKey int
+
+const (
+	msgDestUnmappedText messageKey = iota
+	msgDestUnmapped
+	msgDestConfirmText
+	msgDestConfirm
+	msgRouteAmbiguousText
+	msgRouteAmbiguous
+	msgOr
+	msgRouteUnknownText
+	msgRouteUnknown
+	msgUnavailableText
+	msgUnavailable
+	msgNoRoutesText
+	msgNoRoutes
+	msgNoMoreText
+	msgNoMore
+	msgNoFollowupText
+	msgNoFollowup
+	msgUnknownIntentText
+	msgUnknownIntent
+	msgWelcomeText
+	msgWelcome
+	msgLeavesFrom
+	msgBusLeavesFrom
+	msgNow
+	msgInOneMinute
+	msgInMinutes
+	msgAt
+	msgUpcomingDepartures
+	msgLine
+	msgStop
+	msgScheduled
+	msgRealtime
+	msgDelay
+	msgOnTime
+	msgDelayMinutes
+	msgShowOnMap
+)
+
+// Message catalogue, per language. Messages are fmt formats.
+var messageCatalogue = map[string]map[messageKey]string{
+	langEnglish: {
+		msgDestUnmappedText:   "Destination could not be mapped",
+		msgDestUnmapped:       "Sorry, but destination could not be mapped! Please retry.",
+		msgDestConfirmText:    "Destination needs confirmation",
+		msgDestConfirm:        "Did you mean %s?",
+		msgRouteAmbiguousText: "Route is ambiguous",
+		msgRouteAmbiguous:     "Did you mean %s?",
+		msgOr:                 "or",
+		msgRouteUnknownText:   "Route could not be resolved",
+		msgRouteUnknown:       "Sorry, but route %s is not known! Please retry.",
+		msgUnavailableText:    "Timetable data is temporarily unavailable",
+		msgUnavailable:        "Sorry, but timetable data is temporarily unavailable! Please try again later.",
+		msgNoRoutesText:       "No routes to provided destination",
+		msgNoRoutes:           "Sorry, but no routes were found! Please retry.",
+		msgNoMoreText:         "No more departures",
+		msgNoMore:             "Sorry, but there are no more departures I know of!",
+		msgNoFollowupText:     "Nothing to follow up",
+		msgNoFollowup:         "Sorry, but I don't know which departures you mean. Please ask about a destination first.",
+		msgUnknownIntentText:  "Unsupported question",
+		msgUnknownIntent:      "Sorry, but I can't help with that! Ask me when the next bus leaves to your destination.",
+		msgWelcomeText:        "Welcome",
+		msgWelcome:            "Ask me when the next bus leaves, e.g. when is the next bus to Tapiola?",
+		msgLeavesFrom:         "Leaves from ",
+		msgBusLeavesFrom:      "Bus %s leaves from ",
+		msgNow:                "now",
+		msgInOneMinute:        "in 1 minute",
+		msgInMinutes:          "in %d minutes",
+		msgAt:                 "at ",
+		msgUpcomingDepartures: "Upcoming departures",
+		msgLine:               "Line",
+		msgStop:               "Stop",
+		msgScheduled:          "Scheduled",
+		msgRealtime:           "Realtime",
+		msgDelay:              "Delay",
+		msgOnTime:             "on time",
+		msgDelayMinutes:       "%+d min",
+		msgShowOnMap:          "Show %s on map",
+	},
+	langFinnish: {
+		msgDestUnmappedText:   "Määränpäätä ei tunnistettu",
+		msgDestUnmapped:       "Valitettavasti määränpäätä ei tunnistettu! Yritä uudelleen.",
+		msgDestConfirmText:    "Määränpää pitää vahvistaa",
+		msgDestConfirm:        "Tarkoititko %s?",
+		msgRouteAmbiguousText: "Linja on epäselvä",
+		msgRouteAmbiguous:     "Tarkoititko linjaa %s?",
+		msgOr:                 "vai",
+		msgRouteUnknownText:   "Linjaa ei tunnistettu",
+		msgRouteUnknown:       "Valitettavasti linjaa %s ei tunneta! Yritä uudelleen.",
+		msgUnavailableText:    "Aikataulutiedot eivät ole tilapäisesti saatavilla",
+		msgUnavailable:        "Valitettavasti aikataulutiedot eivät ole tilapäisesti saatavilla! Yritä myöhemmin uudelleen.",
+		msgNoRoutesText:       "Ei reittejä annettuun määränpäähän",
+		msgNoRoutes:           "Valitettavasti reittejä ei löytynyt! Yritä uudelleen.",
+		msgNoMoreText:         "Ei enempää lähtöjä",
+		msgNoMore:             "Valitettavasti en tiedä enempää lähtöjä!",
+		msgNoFollowupText:     "Ei jatkettavaa kysymystä",
+		msgNoFollowup:         "Valitettavasti en tiedä, mitä lähtöjä tarkoitat. Kysy ensin määränpäästä.",
+		msgUnknownIntentText:  "Kysymystä ei tueta",
+		msgUnknownIntent:      "Valitettavasti en osaa auttaa siinä! Kysy, milloin seuraava bussi lähtee määränpäähäsi.",
+		msgWelcomeText:        "Tervetuloa",
+		msgWelcome:            "Kysy, milloin seuraava bussi lähtee, esimerkiksi milloin lähtee seuraava bussi Tapiolaan?",
+		msgLeavesFrom:         "Lähtee pysäkiltä ",
+		msgBusLeavesFrom:      "Bussi %s lähtee pysäkiltä ",
+		msgNow:                "nyt",
+		msgInOneMinute:        "minuutin päästä",
+		msgInMinutes:          "%d minuutin päästä",
+		msgAt:                 "kello ",
+		msgUpcomingDepartures: "Seuraavat lähdöt",
+		msgLine:               "Linja",
+		msgStop:               "Pysäkki",
+		msgScheduled:          "Aikataulu",
+		msgRealtime:           "Ennuste",
+		msgDelay:              "Viive",
+		msgOnTime:             "ajallaan",
+		msgDelayMinutes:       "%+d min",
+		msgShowOnMap:          "Näytä %s kartalla",
+	},
+	langSwedish: {
+		msgDestUnmappedText:   "Destinationen kunde inte tolkas",
+		msgDestUnmapped:       "Tyvärr kunde destinationen inte tolkas! Försök igen.",
+		msgDestConfirmText:    "Destinationen behöver bekräftas",
+		msgDestConfirm:        "Menade du %s?",
+		msgRouteAmbiguousText: "Linjen är tvetydig",
+		msgRouteAmbiguous:     "Menade du linje %s?",
+		msgOr:                 "eller",
+		msgRouteUnknownText:   "Linjen kunde inte tolkas",
+		msgRouteUnknown:       "Tyvärr är linje %s okänd! Försök igen.",
+		msgUnavailableText:    "Tidtabellsdata är tillfälligt otillgängliga",
+		msgUnavailable:        "Tyvärr är tidtabellsdata tillfälligt otillgängliga! Försök igen senare.",
+		msgNoRoutesText:       "Inga rutter till angiven destination",
+		msgNoRoutes:           "Tyvärr hittades inga rutter! Försök igen.",
+		msgNoMoreText:         "Inga fler avgångar",
+		msgNoMore:             "Tyvärr känner jag inte till fler avgångar!",
+		msgNoFollowupText:     "Inget att följa upp",
+		msgNoFollowup:         "Tyvärr vet jag inte vilka avgångar du menar. Fråga först om en destination.",
+		msgUnknownIntentText:  "Frågan stöds inte",
+		msgUnknownIntent:      "Tyvärr kan jag inte hjälpa till med det! Fråga när nästa buss går till din destination.",
+		msgWelcomeText:        "Välkommen",
+		msgWelcome:            "Fråga när nästa buss går, till exempel när går nästa buss till Hagalund?",
+		msgLeavesFrom:         "Avgår från hållplatsen ",
+		msgBusLeavesFrom:      "Buss %s avgår från hållplatsen ",
+		msgNow:                "nu",
+		msgInOneMinute:        "om 1 minut",
+		msgInMinutes:          "om %d minuter",
+		msgAt:                 "klockan ",
+		msgUpcomingDepartures: "Kommande avgångar",
+		msgLine:               "Linje",
+		msgStop:               "Hållplats",
+		msgScheduled:          "Tidtabell",
+		msgRealtime:           "Realtid",
+		msgDelay:              "Försening",
+		msgOnTime:             "i tid",
+		msgDelayMinutes:       "%+d min",
+		msgShowOnMap:          "Visa %s på kartan",
+	},
+}
+
+// Clock format per language, e.g. Finnish writes 15.04
+var clockFormats = map[string]string{
+	langEnglish: "15:04",
+	langFinnish: "15.04",
+	langSwedish: "15:04",
+}
+
+// SSML language of stop names, per reply language
+var stopNameLanguages = map[string]string{
+	langEnglish: "fi-FI",
+	langFinnish: "fi-FI",
+	langSwedish: "sv-FI",
+}
+
+/*
+replyLanguage: Picks the reply language for a languageCode such as "fi-FI" or "sv".
+*/
+func replyLanguage(languageCode string) string {
+	lang := strings.ToLower(languageCode)
+	if indx := strings.IndexAny(lang, "-_"); indx >= 0 {
+		lang = lang[:indx]
+	}
+
+	if _, ok := messageCatalogue[lang]; ok {
+		return lang
+	}
+
+	return langEnglish
+}
+
+/*
+message: Returns the message in the given language, formatted with args.
+*/
+func message(lang string, key messageKey, args ...interface{}) string {
+	format, ok := messageCatalogue[lang][key]
+	if !ok {
+		format = messageCatalogue[langEnglish][key]
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+/*
+localClock: Helper function - Time of day in Helsinki, in the convention of the language.
+*/
+func localClock(t time.Time, lang string) string {
+	format, ok := clockFormats[lang]
+	if !ok {
+		format = clockFormats[langEnglish]
+	}
+
+	return t.In(hslLocation).Format(format)
+}
+
+/*
+localStopName: Returns the stop name to use in the language, and the language to
+pronounce it in. Swedish names are used for Swedish replies when HSL has one.
+*/
+func localStopName(stop stopStruct, lang string) (name string, pronunciation string) {
+	name = stop.name
+	if lang == langSwedish && stop.nameSv != "" {
+		name = stop.nameSv
+	}
+
+	pronunciation, ok := stopNameLanguages[lang]
+	if !ok {
+		pronunciation = stopNameLanguages[langEnglish]
+	}
+	if lang == langSwedish && stop.nameSv == "" {
+		// Only a Finnish name is available
+		pronunciation = stopNameLanguages[langFinnish]
+	}
+
+	return
+}
+
+/*
+orList: Helper function - Joins items as "a, b or c" in the language.
+*/
+func orList(items []string, lang string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+
+	last := len(items) - 1
+
+	return strings.Join(items[:last], ", ") + " " + message(lang, msgOr) + " " + items[last]
+}
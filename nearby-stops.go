@@ -0,0 +1,158 @@
+/*
+nearby-stops.go
+
+Discovers the stops within walking distance of a home coordinate, so they need not be
+listed one by one in "stopGtfsIds".
+- Stops within "homeRadius" meters of "homeLatitude", "homeLongitude" are retrieved
+  with the Digitransit stopsByRadius query, together with the lines serving them.
+- "homeModes", e.g. ["BUS", "TRAM"], keeps only stops served by those modes.
+- Discovered stops are answered for in addition to the ones in "stopGtfsIds".
+- Stops are discovered again daily by default, as stops and lines change with the
+  timetables. The last discovered stops are kept if the HSL API cannot be reached.
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Delay before discovery is retried after a failure
+const nearbyRetryDelay = 5 * time.Minute
+
+// Home configuration, from the config file
+var homeConfigured bool
+var homeLatitude float64
+var homeLongitude float64
+var homeRadius int
+var homeModes []string
+var discoveryInterval time.Duration
+
+/*
+servesModes: Helper function - Checks if the stop is served by one of the modes. Without
+configured modes every stop with lines is accepted.
+*/
+func (nearby nearbyStop) servesModes(modes []string) bool {
+	if len(modes) == 0 {
+		return len(nearby.routes) > 0
+	}
+
+	for _, mode := range nearby.modes {
+		for _, wanted := range modes {
+			if strings.EqualFold(mode, wanted) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+discoverNearbyStops: Retrieves the stops near home served by the configured modes,
+nearest first.
+*/
+func discoverNearbyStops() (stops []nearbyStop, err error) {
+	found, err := getStopsByRadius(homeLatitude, homeLongitude, homeRadius)
+	if err != nil {
+		return
+	}
+
+	for _, nearby := range found {
+		if nearby.servesModes(homeModes) {
+			stops = append(stops, nearby)
+		}
+	}
+
+	return
+}
+
+/*
+mergeStops: Helper function - Configured stops first, then the discovered ones not
+already configured.
+*/
+func mergeStops(configured []string, nearby []nearbyStop) (gtfsIds []string) {
+	seen := make(map[string]bool)
+
+	for _, gtfsId := range configured {
+		seen[gtfsId] = true
+		gtfsIds = append(gtfsIds, gtfsId)
+	}
+
+	for _, stop := range nearby {
+		if !seen[stop.stop.gtfsId] {
+			seen[stop.stop.gtfsId] = true
+			gtfsIds = append(gtfsIds, stop.stop.gtfsId)
+		}
+	}
+
+	return
+}
+
+/*
+refreshNearbyStops: Discovers the stops near home and replaces the stops of the departure
+store with them and the configured stops. New stops are retrieved right away.
+Returns false if discovery failed, the current stops are kept then.
+*/
+func refreshNearbyStops() bool {
+	nearby, err := discoverNearbyStops()
+	if err != nil {
+		log.Error("Nearby stops could not be discovered - ", err)
+		return false
+	}
+
+	for _, stop := range nearby {
+		lines := append([]string(nil), stop.routes...)
+		sort.Strings(lines)
+		log.Info("Nearby stop ", stop.stop.gtfsId, " - ", stop.stop.name, " ", stop.stop.code,
+			" at ", stop.distance, " m, lines ", strings.Join(lines, ", "))
+	}
+
+	gtfsIds := mergeStops(configStopGtfsIds, nearby)
+	if len(gtfsIds) == 0 {
+		log.Error("No stops found within ", homeRadius, " m of home")
+	}
+
+	departureData.setStops(gtfsIds)
+
+	for _, stop := range departureData.missing() {
+		fetchStop(stop)
+	}
+
+	return true
+}
+
+/*
+startStopDiscovery: Starts discovering the stops near home again in a go routine, if a
+home coordinate is configured. Discovered tells if the first discovery succeeded,
+otherwise it is retried soon.
+*/
+func startStopDiscovery(discovered bool) {
+	if !homeConfigured {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		delay := discoveryInterval
+		if !discovered {
+			delay = nearbyRetryDelay
+		}
+
+		for {
+			log.Debug("Next nearby stops discovery in ", delay)
+			time.Sleep(delay)
+
+			delay = discoveryInterval
+			if !refreshNearbyStops() {
+				delay = nearbyRetryDelay
+			}
+		}
+	}()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeStops(t *testing.T) {
+	nearby := func(gtfsIds ...string) (stops []nearbyStop) {
+		for _, gtfsId := range gtfsIds {
+			stops = append(stops, nearbyStop{stop: stopStruct{gtfsId: gtfsId}})
+		}
+		return
+	}
+
+	tests := []struct {
+		name       string
+		configured []string
+		nearby     []nearbyStop
+		want       []string
+	}{
+		{"nothing", nil, nil, nil},
+		{"configured only", []string{"HSL:1", "HSL:2"}, nil, []string{"HSL:1", "HSL:2"}},
+		{"nearby only, nearest first", nil, nearby("HSL:3", "HSL:4"), []string{"HSL:3", "HSL:4"}},
+		{"configured first", []string{"HSL:1"}, nearby("HSL:3"), []string{"HSL:1", "HSL:3"}},
+		{"configured stop found nearby", []string{"HSL:1", "HSL:2"}, nearby("HSL:2", "HSL:3"), []string{"HSL:1", "HSL:2", "HSL:3"}},
+		{"stop found twice nearby", []string{"HSL:1"}, nearby("HSL:3", "HSL:3"), []string{"HSL:1", "HSL:3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeStops(tt.configured, tt.nearby); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeStops() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServesModes(t *testing.T) {
+	bus := nearbyStop{routes: []string{"215", "214"}, modes: []string{"BUS", "BUS"}}
+	tram := nearbyStop{routes: []string{"15"}, modes: []string{"TRAM"}}
+	unused := nearbyStop{}
+
+	tests := []struct {
+		name  string
+		stop  nearbyStop
+		modes []string
+		want  bool
+	}{
+		{"any mode", bus, nil, true},
+		{"stop without lines", unused, nil, false},
+		{"mode served", bus, []string{"BUS"}, true},
+		{"mode in lower case", tram, []string{"bus", "tram"}, true},
+		{"mode not served", tram, []string{"BUS"}, false},
+		{"stop without lines and modes", unused, []string{"BUS"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stop.servesModes(tt.modes); got != tt.want {
+				t.Errorf("servesModes(%q) = %v, want %v", tt.modes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+refresh.go
+
+Periodic background refresh of the route information.
+- Every configured and nearby bus stop is re-queried from the HSL API on a configurable interval.
+- A faster interval is used during configured rush hours, and a random jitter is added
+  so that the HSL API is not hit at exactly the same moment every time.
+- Snapshots are swapped in the departure store per stop, and the last good data of a
+  stop is kept whenever its refresh fails.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Refresh configuration
+var refreshInterval time.Duration
+var rushHourInterval time.Duration
+var refreshJitter time.Duration
+var rushHours []rushHourWindow
+
+/*
+parseRushHours: Parses rush hour windows of the form "07:00-09:30" into
+minutes since midnight. A window may wrap over midnight, e.g. "23:00-01:00".
+*/
+func parseRushHours(windows []string) (parsed []rushHourWindow, err error) {
+	for _, window := range windows {
+		bounds := strings.Split(window, "-")
+		if len(bounds) != 2 {
+			err = fmt.Errorf("invalid rush hour window %q, expected HH:MM-HH:MM", window)
+			return
+		}
+
+		var rw rushHourWindow
+		if rw.start, err = parseClock(bounds[0]); err != nil {
+			return
+		}
+		if rw.end, err = parseClock(bounds[1]); err != nil {
+			return
+		}
+		parsed = append(parsed, rw)
+	}
+
+	return
+}
+
+/*
+parseClock: Helper function - Converts "HH:MM" into minutes since midnight.
+*/
+func parseClock(clock string) (minutes int, err error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		err = fmt.Errorf("invalid time of day %q: %v", clock, err)
+		return
+	}
+
+	minutes = t.Hour()*60 + t.Minute()
+	return
+}
+
+/*
+isRushHour: Checks if the given time falls in one of the configured rush hour windows.
+Rush hours are in Helsinki local time, so the time should be given in hslLocation.
+*/
+func isRushHour(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+
+	for _, rw := range rushHours {
+		if rw.start <= rw.end {
+			if minutes >= rw.start && minutes < rw.end {
+				return true
+			}
+		} else if minutes >= rw.start || minutes < rw.end {
+			// Window wraps over midnight
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+nextRefreshDelay: Returns how long to wait before the next refresh, including jitter.
+*/
+func nextRefreshDelay(now time.Time) (delay time.Duration) {
+	delay = refreshInterval
+	if isRushHour(now.In(hslLocation)) {
+		delay = rushHourInterval
+	}
+
+	if refreshJitter > 0 {
+		delay = delay + time.Duration(rand.Int63n(int64(refreshJitter)))
+	}
+
+	return
+}
+
+/*
+refreshRouteInfo: Re-queries every stop of the departure store and swaps the new snapshots
+into it. Stops that could not be refreshed keep their previous data.
+*/
+func refreshRouteInfo() {
+	for _, stop := range departureData.stopIds() {
+		fetchStop(stop)
+	}
+
+	log.Debug("Route info refreshed, store version - ", departureData.currentVersion())
+}
+
+/*
+startRefresher: Starts the background refresh loop in a go routine.
+*/
+func startRefresher() {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			delay := nextRefreshDelay(time.Now())
+			log.Debug("Next route info refresh in ", delay)
+			time.Sleep(delay)
+
+			refreshRouteInfo()
+		}
+	}()
+}
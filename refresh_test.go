@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRefreshDelay(t *testing.T) {
+	refreshInterval = 10 * time.Minute
+	rushHourInterval = 2 * time.Minute
+	rushHours = []rushHourWindow{
+		{start: 7 * 60, end: 9*60 + 30},
+		{start: 23 * 60, end: 1 * 60},
+	}
+	defer func() { rushHours, refreshJitter = nil, 0 }()
+
+	day := func(hour, min int) time.Time {
+		return time.Date(2020, 3, 2, hour, min, 0, 0, hslLocation)
+	}
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		jitter time.Duration
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"outside rush hours", day(12, 0), 0, 10 * time.Minute, 10 * time.Minute},
+		{"start of rush hour", day(7, 0), 0, 2 * time.Minute, 2 * time.Minute},
+		{"end of rush hour is excluded", day(9, 30), 0, 10 * time.Minute, 10 * time.Minute},
+		{"window wrapping over midnight, before", day(23, 30), 0, 2 * time.Minute, 2 * time.Minute},
+		{"window wrapping over midnight, after", day(0, 30), 0, 2 * time.Minute, 2 * time.Minute},
+		{"rush hour in Helsinki time", day(8, 0).UTC(), 0, 2 * time.Minute, 2 * time.Minute},
+		{"jitter is added", day(12, 0), 20 * time.Second, 10 * time.Minute, 10*time.Minute + 20*time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refreshJitter = tt.jitter
+
+			for i := 0; i < 20; i++ {
+				delay := nextRefreshDelay(tt.now)
+				if delay < tt.min || delay > tt.max {
+					t.Fatalf("nextRefreshDelay(%v) = %v, want %v..%v", tt.now, delay, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
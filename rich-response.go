@@ -0,0 +1,222 @@
+/*
+rich-response.go
+
+Visual responses for Google Assistant devices with a screen, e.g. Nest Hub and phones.
+- Departures from a single stop are shown in a basic card with the stop name, its
+  departures and a map link.
+- Departures from several stops are shown in a table card with line, stop, scheduled and
+  realtime time and delay. Actions on Google allows only one card per response.
+- Configured call signs are offered as suggestion chips.
+- Cards are built from the same departures the spoken answer was built from, in the
+  language of the answer.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Limits set by Actions on Google
+const (
+	maxTableRows        = 10
+	maxSuggestions      = 8
+	maxSuggestionLength = 25
+)
+
+// Capability of Actions on Google surfaces that can show cards
+const screenCapability string = "actions.capability.SCREEN_OUTPUT"
+
+// A departure together with the stop it leaves from
+type stopDeparture struct {
+	stop   stopStruct
+	arrDep routeArrDepDetails
+}
+
+/*
+hasScreen: Checks if the Assistant surface the request came from can display cards.
+*/
+func hasScreen(webHookReq dfWebhookRequest) bool {
+	surface, _ := webHookReq.OriginalDetectIntentRequest.Payload["surface"].(map[string]interface{})
+	capabilities, _ := surface["capabilities"].([]interface{})
+
+	for _, capability := range capabilities {
+		if c, ok := capability.(map[string]interface{}); ok && c["name"] == screenCapability {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+richItems: Returns the card for the departures of the answer, nil if there are none.
+*/
+func richItems(answer routeAnswer) (items []itemStruct) {
+	switch len(answer.departures) {
+	case 0:
+		return
+	case 1:
+		items = append(items, itemStruct{BasicCard: stopBasicCard(answer.departures[0], answer.language)})
+	default:
+		items = append(items, itemStruct{TableCard: departureTableCard(answer.departures, answer.language)})
+	}
+
+	return
+}
+
+/*
+stopBasicCard: Basic card with the stop name, its departures and a link to the stop on a map.
+*/
+func stopBasicCard(stopDeps stopDepartures, lang string) *basicCardStruct {
+	var lines []string
+	for _, arrDep := range stopDeps.departures {
+		lines = append(lines, "**"+arrDep.route+"** "+departureSummary(arrDep, lang))
+	}
+
+	name, _ := localStopName(stopDeps.stop, lang)
+
+	return &basicCardStruct{
+		Title:         name,
+		Subtitle:      stopDeps.stop.code,
+		FormattedText: strings.Join(lines, "  \n"),
+		Buttons:       []buttonStruct{mapButton(stopDeps.stop, lang)},
+	}
+}
+
+/*
+departureTableCard: Table card of the earliest departures over all stops.
+*/
+func departureTableCard(matches []stopDepartures, lang string) *tableCardStruct {
+	var flat []stopDeparture
+	for _, stopDeps := range matches {
+		for _, arrDep := range stopDeps.departures {
+			flat = append(flat, stopDeparture{stop: stopDeps.stop, arrDep: arrDep})
+		}
+	}
+
+	sort.SliceStable(flat, func(i, j int) bool {
+		return flat[i].arrDep.departureTime().Before(flat[j].arrDep.departureTime())
+	})
+
+	if len(flat) > maxTableRows {
+		flat = flat[:maxTableRows]
+	}
+
+	card := &tableCardStruct{
+		Title: message(lang, msgUpcomingDepartures),
+		ColumnProperties: []columnPropertiesStruct{
+			{Header: message(lang, msgLine)},
+			{Header: message(lang, msgStop)},
+			{Header: message(lang, msgScheduled), HorizontalAlignment: "CENTER"},
+			{Header: message(lang, msgRealtime), HorizontalAlignment: "CENTER"},
+			{Header: message(lang, msgDelay), HorizontalAlignment: "TRAILING"},
+		},
+		Buttons: []buttonStruct{mapButton(matches[0].stop, lang)},
+	}
+
+	for _, dep := range flat {
+		realtime, delay := "-", "-"
+		if dep.arrDep.realtime {
+			realtime = localClock(dep.arrDep.realtimeDepartureTime, lang)
+			delay = formatDelay(dep.arrDep.departureDelay, lang)
+		}
+
+		name, _ := localStopName(dep.stop, lang)
+
+		card.Rows = append(card.Rows, tableRowStruct{
+			Cells: []tableCellStruct{
+				{Text: dep.arrDep.route},
+				{Text: name + " (" + dep.stop.code + ")"},
+				{Text: localClock(dep.arrDep.scheduledDepartureTime, lang)},
+				{Text: realtime},
+				{Text: delay},
+			},
+		})
+	}
+
+	return card
+}
+
+/*
+callSignSuggestions: Suggestion chips for the configured call signs, in alphabetical order.
+*/
+func callSignSuggestions() (suggestions []suggestionStruct) {
+	var callSigns []string
+	for callSign := range configSigns {
+		callSigns = append(callSigns, callSign)
+	}
+	sort.Strings(callSigns)
+
+	for _, callSign := range callSigns {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+
+		title := capitalise(callSign)
+		if len([]rune(title)) > maxSuggestionLength {
+			continue
+		}
+		suggestions = append(suggestions, suggestionStruct{Title: title})
+	}
+
+	return
+}
+
+/*
+mapButton: Helper function - Button that opens the stop in Google Maps.
+*/
+func mapButton(stop stopStruct, lang string) buttonStruct {
+	name, _ := localStopName(stop, lang)
+
+	return buttonStruct{
+		Title: message(lang, msgShowOnMap, name),
+		OpenUrlAction: openUrlActionStruct{
+			Url: fmt.Sprintf("https://www.google.com/maps/search/?api=1&query=%f,%f", stop.latitude, stop.longitude),
+		},
+	}
+}
+
+/*
+departureSummary: Helper function - Headsign and departure time of a departure, with the
+realtime departure and delay when known, e.g. "Leppävaara 15:04 → 15:06 (+2 min)".
+*/
+func departureSummary(arrDep routeArrDepDetails, lang string) string {
+	summary := arrDep.headSign + " " + localClock(arrDep.scheduledDepartureTime, lang)
+	if arrDep.realtime {
+		summary = summary + " → " + localClock(arrDep.realtimeDepartureTime, lang) + " (" + formatDelay(arrDep.departureDelay, lang) + ")"
+	}
+
+	return summary
+}
+
+/*
+formatDelay: Helper function - Delay in whole minutes, e.g. "+2 min" or "on time".
+*/
+func formatDelay(seconds float64, lang string) string {
+	minutes := int(seconds / 60)
+
+	if minutes == 0 {
+		return message(lang, msgOnTime)
+	}
+
+	return message(lang, msgDelayMinutes, minutes)
+}
+
+/*
+capitalise: Helper function - Upper cases the first letter of every word. Config keys
+are handed out in lower case.
+*/
+func capitalise(phrase string) string {
+	words := strings.Fields(phrase)
+	for indx, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[indx] = string(runes)
+	}
+
+	return strings.Join(words, " ")
+}
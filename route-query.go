@@ -0,0 +1,286 @@
+/*
+route-query.go
+
+Answers departure queries independent of the webhook they arrived through.
+- Dialogflow ES and CX webhooks parse their own request formats into a route query.
+- The query is answered here: destinations and routes are resolved, departures looked up
+  and phrased for speech in the language of the request.
+- Each webhook formats the answer into its own response format.
+- Follow-up questions (Next-After, Change-Route, Confirm) continue from the query of the
+  previous answer, which the webhooks keep in the conversation.
+*/
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How a query was answered
+type answerStatus int
+
+const (
+	// Departures were found
+	answerOK answerStatus = iota
+	// The user has to clarify the query, e.g. pick a route
+	answerPrompt
+	// The query could not be answered
+	answerError
+)
+
+// A departure query: Destination-Only or Bus-Destination, answered in the given language.
+// After is the departure time of the last departure already told, zero for a new query.
+// Confirm marks a query the user was asked to confirm, e.g. an uncertain destination.
+type routeQuery struct {
+	request     string
+	route       string
+	destination string
+	language    string
+	after       time.Time
+	confirm     bool
+}
+
+// Number of departures told in one answer. Departures leaving at the same time as the
+// last of them are told as well.
+const departuresPerAnswer int = 3
+
+// Answer to a departure query. Speech holds one line per stop or bus, departures
+// the data the speech was built from, as of now. Followup is the query to continue
+// from, its destination is empty if there is nothing to follow up.
+type routeAnswer struct {
+	status          answerStatus
+	fulfillmentText string
+	speech          []spokenText
+	departures      []stopDepartures
+	now             time.Time
+	language        string
+	followup        routeQuery
+}
+
+/*
+errorAnswer: Helper function - Answer for a query that could not be answered.
+*/
+func errorAnswer(lang string, fulfillmentText string, speech string) routeAnswer {
+	return routeAnswer{status: answerError, fulfillmentText: fulfillmentText, speech: []spokenText{plainSpeech(speech)}, language: lang}
+}
+
+/*
+promptAnswer: Helper function - Answer that asks the user to clarify the query.
+*/
+func promptAnswer(lang string, fulfillmentText string, speech string) routeAnswer {
+	return routeAnswer{status: answerPrompt, fulfillmentText: fulfillmentText, speech: []spokenText{plainSpeech(speech)}, language: lang}
+}
+
+/*
+followupQuery: Completes a follow-up question from the query the conversation continues
+from. Next-After repeats the previous query for the departures after the ones told,
+Change-Route asks for another route to the previous destination, and Confirm answers
+the query the user was asked to confirm. Other queries are returned as they are.
+Returns false if there is no previous query to continue from.
+*/
+func followupQuery(query routeQuery, previous routeQuery) (routeQuery, bool) {
+	switch query.request {
+	case NEXTAFTER:
+		if previous.destination == "" {
+			return query, false
+		}
+
+		previous.language = query.language
+		return previous, true
+	case CHANGEROUTE:
+		if query.destination == "" {
+			query.destination = previous.destination
+		}
+
+		if query.destination == "" {
+			return query, false
+		}
+
+		query.request = BUSDEST
+		query.after = time.Time{}
+		return query, true
+	case CONFIRM:
+		if !previous.confirm || previous.destination == "" {
+			return query, false
+		}
+
+		previous.language = query.language
+		previous.confirm = false
+		return previous, true
+	}
+
+	return query, true
+}
+
+/*
+parameters: Returns the query as context parameters, for keeping it in the conversation.
+The last departure told is kept as an RFC 3339 timestamp, empty for a new query.
+*/
+func (query routeQuery) parameters() map[string]interface{} {
+	after := ""
+	if !query.after.IsZero() {
+		after = query.after.In(hslLocation).Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"request":     query.request,
+		"route":       query.route,
+		"destination": query.destination,
+		"after":       after,
+		"confirm":     query.confirm,
+	}
+}
+
+/*
+queryFromParameters: Helper function - Reads a query kept in the conversation back from
+context parameters. The route may have been overwritten by the agent with a route
+parameter in its spoken form.
+*/
+func queryFromParameters(params map[string]interface{}) (query routeQuery) {
+	request, _ := params["request"].(string)
+
+	// Only complete queries can be continued
+	switch strings.ToLower(request) {
+	case strings.ToLower(DESTONLY):
+		query.request = DESTONLY
+	case strings.ToLower(BUSDEST):
+		query.request = BUSDEST
+	default:
+		return
+	}
+
+	query.route = spokenRoute(params["route"])
+	query.destination, _ = params["destination"].(string)
+
+	if after, ok := params["after"].(string); ok && after != "" {
+		var err error
+		if query.after, err = time.Parse(time.RFC3339, after); err != nil {
+			log.Error("Invalid last departure in follow-up - ", after)
+		}
+	}
+
+	query.confirm, _ = params["confirm"].(bool)
+
+	return
+}
+
+/*
+answerRouteQuery: Resolves the destination and route of the query and looks up the
+matching departures. Departures older than the cache TTL are fetched first. The
+earliest departures are told, follow-ups continue strictly after the last of them.
+*/
+func answerRouteQuery(query routeQuery) routeAnswer {
+
+	// Make sure answers are not based on stale departures
+	ensureFresh(cacheTTL)
+
+	lang := query.language
+	if lang == "" {
+		lang = langEnglish
+	}
+
+	resolved := resolveDestination(query.destination)
+	log.Debug("Destination - ", query.destination, " resolved to - ", resolved)
+
+	if resolved.confidence < destinationPlausible {
+		log.Error("Destination could not be mapped - ", query.destination)
+		return errorAnswer(lang, message(lang, msgDestUnmappedText), message(lang, msgDestUnmapped))
+	}
+
+	if resolved.confidence < destinationConfident {
+		log.Info("Destination - ", query.destination, " uncertain, asking to confirm - ", resolved.callSign)
+		answer := promptAnswer(lang, message(lang, msgDestConfirmText), message(lang, msgDestConfirm, resolved.callSign))
+
+		// A yes comes back as Confirm and asks again with the call sign
+		answer.followup = query
+		answer.followup.language = ""
+		answer.followup.destination = resolved.callSign
+		answer.followup.confirm = true
+		return answer
+	}
+
+	dest := resolved.destination
+	route := query.route
+	now := time.Now()
+
+	from := now.Add(-departureGrace)
+	if !query.after.Before(from) {
+		from = query.after.Add(time.Nanosecond)
+	}
+
+	var matches []stopDepartures
+	var routes []spokenText
+	var last time.Time
+
+	switch query.request {
+	case BUSDEST:
+		resolution := resolveRoute(route)
+		if resolution.ambiguous() {
+			log.Info("Route - ", route, " is ambiguous, candidates - ", resolution.candidates)
+			answer := promptAnswer(lang, message(lang, msgRouteAmbiguousText), resolution.disambiguationPrompt(lang))
+
+			// The picked route comes back as Change-Route, a yes to a single line as Confirm
+			answer.followup = routeQuery{request: BUSDEST, destination: resolved.callSign}
+			if len(resolution.candidates) == 1 {
+				answer.followup.route = resolution.candidates[0]
+				answer.followup.confirm = true
+			}
+			return answer
+		}
+
+		if resolution.route == "" {
+			log.Error("Route could not be resolved - ", route)
+			return errorAnswer(lang, message(lang, msgRouteUnknownText), message(lang, msgRouteUnknown, route))
+		}
+
+		route = resolution.route
+		matches, last = firstDepartures(departureData.departures(route, dest, from), departuresPerAnswer)
+		routes = GetBusDestinationHandler(matches, now, lang)
+	case DESTONLY:
+		matches, last = firstDepartures(departureData.departures("", dest, from), departuresPerAnswer)
+		routes = GetDestinationHandler(matches, now, lang)
+	default:
+		log.Error("Unsupported handler type - ", query.request, "Internal error!!")
+	}
+
+	if len(routes) == 0 {
+		// Nothing found might just mean HSL API has not been reachable
+		if unavailable := unavailableStops(cacheTTL, now); len(unavailable) > 0 {
+			log.Error("No current timetable data for stops - ", unavailable)
+			return errorAnswer(lang, message(lang, msgUnavailableText), message(lang, msgUnavailable))
+		}
+
+		if !query.after.IsZero() {
+			log.Info("No departures after ", query.after, " for route-", route, " destination-", query.destination)
+			return errorAnswer(lang, message(lang, msgNoMoreText), message(lang, msgNoMore))
+		}
+
+		log.Error("Route-", route, " Destination-", query.destination, " mismatch")
+		return errorAnswer(lang, message(lang, msgNoRoutesText), message(lang, msgNoRoutes))
+	}
+
+	// Only two simple responses are expected. Every departure told has to be spoken,
+	// so that follow-ups do not skip any.
+	if len(routes) > 2 {
+		routes = append(routes[:1], joinSpeech(routes[1:], ". "))
+	}
+
+	// Plain text of the speech is the fallback for clients without SSML
+	return routeAnswer{
+		status:          answerOK,
+		fulfillmentText: joinSpeech(routes, ". ").text,
+		speech:          routes,
+		departures:      matches,
+		now:             now,
+		language:        lang,
+		followup: routeQuery{
+			request:     query.request,
+			route:       route,
+			destination: resolved.callSign,
+			after:       last,
+		},
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupTestDepartures fills the departure store with 215 and 214 departures to Sello
+// from a single stop, leaving every few minutes from now on.
+func setupTestDepartures(t *testing.T, now time.Time) {
+	configSigns = map[string]destinationSpec{"Sello": {headsigns: []string{"Leppävaara"}}}
+	configRoutes = []string{"215", "214", "231N"}
+	cacheTTL = 0
+
+	var arrDeps []routeArrDepDetails
+	for i := 1; i <= 6; i++ {
+		arrDeps = append(arrDeps,
+			routeArrDepDetails{route: "215", headSign: "Leppävaara", scheduledDepartureTime: now.Add(time.Duration(i*4) * time.Minute)},
+			routeArrDepDetails{route: "214", headSign: "Leppävaara", scheduledDepartureTime: now.Add(time.Duration(i*5) * time.Minute)})
+	}
+
+	departureData = newDepartureStore([]string{"HSL:1"})
+	departureData.update("HSL:1", routeData{
+		stopDetails:   stopStruct{gtfsId: "HSL:1", name: "Jupperinympyrä", code: "E1439"},
+		arrDepDetails: arrDeps,
+	})
+
+	t.Cleanup(func() { configSigns, configRoutes, departureData = nil, nil, nil })
+}
+
+func TestFollowupQuery(t *testing.T) {
+	told := time.Date(2020, 3, 2, 15, 4, 0, 0, hslLocation)
+	previous := routeQuery{request: BUSDEST, route: "215", destination: "Sello", after: told}
+
+	tests := []struct {
+		name     string
+		query    routeQuery
+		previous routeQuery
+		want     routeQuery
+		ok       bool
+	}{
+		{"new query is kept", routeQuery{request: DESTONLY, destination: "Tapiola"}, previous,
+			routeQuery{request: DESTONLY, destination: "Tapiola"}, true},
+		{"next after repeats previous", routeQuery{request: NEXTAFTER, language: langFinnish}, previous,
+			routeQuery{request: BUSDEST, route: "215", destination: "Sello", after: told, language: langFinnish}, true},
+		{"next after without previous", routeQuery{request: NEXTAFTER}, routeQuery{},
+			routeQuery{request: NEXTAFTER}, false},
+		{"change route keeps destination", routeQuery{request: CHANGEROUTE, route: "214"}, previous,
+			routeQuery{request: BUSDEST, route: "214", destination: "Sello"}, true},
+		{"change route with destination", routeQuery{request: CHANGEROUTE, route: "214", destination: "Tapiola"}, routeQuery{},
+			routeQuery{request: BUSDEST, route: "214", destination: "Tapiola"}, true},
+		{"change route without destination", routeQuery{request: CHANGEROUTE, route: "214"}, routeQuery{},
+			routeQuery{request: CHANGEROUTE, route: "214"}, false},
+		{"confirm answers the suggestion", routeQuery{request: CONFIRM, language: langSwedish},
+			routeQuery{request: DESTONLY, destination: "Sello", confirm: true},
+			routeQuery{request: DESTONLY, destination: "Sello", language: langSwedish}, true},
+		{"confirm without suggestion", routeQuery{request: CONFIRM}, previous,
+			routeQuery{request: CONFIRM}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := followupQuery(tt.query, tt.previous)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("followupQuery() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestQueryParametersRoundTrip(t *testing.T) {
+	tests := []routeQuery{
+		{request: DESTONLY, destination: "Sello"},
+		{request: BUSDEST, route: "215", destination: "Sello", after: time.Date(2020, 3, 2, 15, 4, 0, 0, hslLocation)},
+		{request: BUSDEST, route: "231N", destination: "Sello", confirm: true},
+	}
+
+	for _, query := range tests {
+		got := queryFromParameters(query.parameters())
+
+		if !got.after.Equal(query.after) {
+			t.Errorf("after = %v, want %v", got.after, query.after)
+		}
+		got.after = query.after
+		if got != query {
+			t.Errorf("queryFromParameters(%+v.parameters()) = %+v", query, got)
+		}
+	}
+}
+
+// Layout of told departures, sortable also over midnight
+const toldLayout = "01-02 15:04"
+
+// toldDepartures returns the departure times and routes of the answer, earliest first.
+func toldDepartures(answer routeAnswer) (told []string) {
+	first, _ := firstDepartures(answer.departures, 100)
+	for _, stopDeps := range first {
+		for _, arrDep := range stopDeps.departures {
+			told = append(told, arrDep.departureTime().Format(toldLayout)+" "+arrDep.route)
+		}
+	}
+
+	sort.Strings(told)
+	return
+}
+
+func TestFollowupContinuesAfterLastTold(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	setupTestDepartures(t, now)
+
+	at := func(minutes int) string {
+		return now.Add(time.Duration(minutes) * time.Minute).Format(toldLayout)
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		route   string
+		told    [][]string
+	}{
+		{"destination only", DESTONLY, "", [][]string{
+			{at(4) + " 215", at(5) + " 214", at(8) + " 215"},
+			{at(10) + " 214", at(12) + " 215", at(15) + " 214"},
+			{at(16) + " 215", at(20) + " 214", at(20) + " 215"},
+			{at(24) + " 215", at(25) + " 214", at(30) + " 214"},
+		}},
+		{"bus and destination", BUSDEST, "214", [][]string{
+			{at(5) + " 214", at(10) + " 214", at(15) + " 214"},
+			{at(20) + " 214", at(25) + " 214", at(30) + " 214"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer := answerRouteQuery(routeQuery{request: tt.request, route: tt.route, destination: "Sello", language: langEnglish})
+
+			for turn, want := range tt.told {
+				if answer.status != answerOK {
+					t.Fatalf("turn %d: status = %v %q, want departures", turn, answer.status, answer.fulfillmentText)
+				}
+				if got := toldDepartures(answer); !reflect.DeepEqual(got, want) {
+					t.Errorf("turn %d: told %v, want %v", turn, got, want)
+				}
+
+				answer = answerRouteQuery(answer.followup)
+			}
+
+			if answer.status != answerError || answer.speech[0].text != message(langEnglish, msgNoMore) {
+				t.Errorf("after the last departure: %v %q, want no more departures", answer.status, answer.fulfillmentText)
+			}
+		})
+	}
+}
+
+func TestFollowupAfterDepartureLeft(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	setupTestDepartures(t, now)
+
+	answer := answerRouteQuery(routeQuery{request: BUSDEST, route: "215", destination: "Sello", language: langEnglish})
+
+	// The first bus told leaves and drops out of the stored departures
+	snap, _ := departureData.stop("HSL:1")
+	departureData.update("HSL:1", routeData{
+		stopDetails:   snap.data.stopDetails,
+		arrDepDetails: snap.data.arrDepDetails[2:],
+	})
+
+	next := answerRouteQuery(answer.followup)
+	want := []string{
+		now.Add(16*time.Minute).Format(toldLayout) + " 215",
+		now.Add(20*time.Minute).Format(toldLayout) + " 215",
+		now.Add(24*time.Minute).Format(toldLayout) + " 215",
+	}
+	if got := toldDepartures(next); !reflect.DeepEqual(got, want) {
+		t.Errorf("told %v, want %v", got, want)
+	}
+}
+
+func TestFirstDepartures(t *testing.T) {
+	base := time.Date(2020, 3, 2, 15, 0, 0, 0, hslLocation)
+	dep := func(route string, minutes int) routeArrDepDetails {
+		return routeArrDepDetails{route: route, scheduledDepartureTime: base.Add(time.Duration(minutes) * time.Minute)}
+	}
+	matches := []stopDepartures{
+		{stop: stopStruct{gtfsId: "HSL:1"}, departures: []routeArrDepDetails{dep("215", 2), dep("215", 9), dep("214", 6)}},
+		{stop: stopStruct{gtfsId: "HSL:2"}, departures: []routeArrDepDetails{dep("215", 6), dep("215", 12)}},
+	}
+
+	tests := []struct {
+		name  string
+		n     int
+		count int
+		last  int
+	}{
+		{"first only", 1, 1, 2},
+		{"ties at the last are kept", 2, 3, 6},
+		{"more than available", 10, 5, 12},
+		{"none", 0, 0, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, last := firstDepartures(matches, tt.n)
+
+			count := 0
+			for _, stopDeps := range first {
+				count += len(stopDeps.departures)
+			}
+			if count != tt.count {
+				t.Errorf("kept %d departures, want %d", count, tt.count)
+			}
+
+			wantLast := time.Time{}
+			if tt.last >= 0 {
+				wantLast = base.Add(time.Duration(tt.last) * time.Minute)
+			}
+			if !last.Equal(wantLast) {
+				t.Errorf("last = %v, want %v", last, wantLast)
+			}
+		})
+	}
+}
+
+func TestConfirmUncertainMatches(t *testing.T) {
+	setupTestDepartures(t, time.Now())
+
+	tests := []struct {
+		name     string
+		query    routeQuery
+		prompt   string
+		followup routeQuery
+	}{
+		{"uncertain destination", routeQuery{request: DESTONLY, destination: "sallow"},
+			"Did you mean Sello?", routeQuery{request: DESTONLY, destination: "Sello", confirm: true}},
+		{"partial route", routeQuery{request: BUSDEST, route: "231", destination: "Sello"},
+			"Did you mean 231N?", routeQuery{request: BUSDEST, route: "231N", destination: "Sello", confirm: true}},
+		{"several routes", routeQuery{request: BUSDEST, route: "21", destination: "Sello"},
+			"Did you mean 214 or 215?", routeQuery{request: BUSDEST, destination: "Sello"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.language = langEnglish
+			answer := answerRouteQuery(tt.query)
+
+			if answer.status != answerPrompt {
+				t.Fatalf("status = %v, want a prompt", answer.status)
+			}
+			if answer.speech[0].text != tt.prompt {
+				t.Errorf("prompt = %q, want %q", answer.speech[0].text, tt.prompt)
+			}
+			if answer.followup != tt.followup {
+				t.Errorf("followup = %+v, want %+v", answer.followup, tt.followup)
+			}
+		})
+	}
+
+	// A yes to the uncertain destination answers with the suggested one
+	answer := dispatchIntent(CONFIRM, func(string) (interface{}, bool) { return nil, false }, langEnglish,
+		routeQuery{request: DESTONLY, destination: "Sello", confirm: true})
+	if answer.status != answerOK || !strings.Contains(answer.fulfillmentText, "215") {
+		t.Errorf("confirmed answer = %v %q, want departures", answer.status, answer.fulfillmentText)
+	}
+	if answer.followup.confirm {
+		t.Error("confirmed answer must not ask to confirm again")
+	}
+}
+
+func TestStaleDataAfterFailedFetch(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		failed bool
+		want   string
+	}{
+		{"fetch failed after the buses left", time.Minute, true, message(langEnglish, msgUnavailable)},
+		{"fetching disabled", 0, true, message(langEnglish, msgNoRoutes)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every stored bus has left by now
+			now := time.Now()
+			setupTestDepartures(t, now.Add(-time.Hour))
+			cacheTTL = tt.ttl
+			departureData.stops["HSL:1"].updated = now.Add(-time.Hour)
+
+			if tt.failed {
+				stopFetches.lock.Lock()
+				stopFetches.failed["HSL:1"] = now
+				stopFetches.lock.Unlock()
+			}
+			t.Cleanup(func() {
+				cacheTTL = 0
+				stopFetches.lock.Lock()
+				delete(stopFetches.failed, "HSL:1")
+				stopFetches.lock.Unlock()
+			})
+
+			answer := answerRouteQuery(routeQuery{request: BUSDEST, route: "215", destination: "Sello", language: langEnglish})
+			if answer.status != answerError || answer.speech[0].text != tt.want {
+				t.Errorf("answer %v %q, want error %q", answer.status, answer.speech[0].text, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/*
+route-resolver.go
+
+Resolves spoken route identifiers into known lines.
+- Dialogflow hands over routes in many shapes: [2,1,4], [21,4], "231N", "night bus 231".
+- Spoken identifiers are normalised and matched exactly against the lines that serve
+  the configured stops.
+- Lines that only start with the spoken identifier are never taken as the route. They
+  are returned as candidates, so the user can be asked which one was meant.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Words that carry no information about the line itself
+var routeFillerWords = map[string]bool{
+	"the":    true,
+	"bus":    true,
+	"line":   true,
+	"route":  true,
+	"number": true,
+	"linja":  true,
+	"bussi":  true,
+}
+
+// Words that turn a line into its night variant, e.g. "night bus 231" is 231N
+var routeNightWords = map[string]bool{
+	"night": true,
+	"yö":    true,
+	"yo":    true,
+}
+
+// Outcome of resolving a spoken route
+type routeResolution struct {
+	spoken     string
+	route      string
+	candidates []string
+}
+
+/*
+spokenRoute: Helper function - Flattens the route parameter from Dialogflow into a
+single string. Numbers in a list are concatenated, so [2,1,4] and [21,4] both
+become "214".
+*/
+func spokenRoute(param interface{}) (spoken string) {
+	switch value := param.(type) {
+	case []interface{}:
+		for _, part := range value {
+			spoken = spoken + spokenRoute(part)
+		}
+	case float64:
+		spoken = fmt.Sprintf("%.0f", value)
+	case string:
+		spoken = value
+	}
+
+	return
+}
+
+/*
+normaliseRoute: Normalises a spoken route identifier into the HSL short name form,
+e.g. "night bus 231" and "231 n" become "231N".
+*/
+func normaliseRoute(spoken string) string {
+	var normalised strings.Builder
+	night := false
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(spoken), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '.'
+	}) {
+		switch {
+		case routeFillerWords[word]:
+			continue
+		case routeNightWords[word]:
+			night = true
+		default:
+			normalised.WriteString(strings.ToUpper(word))
+		}
+	}
+
+	route := normalised.String()
+	if night && route != "" && !strings.HasSuffix(route, "N") {
+		route = route + "N"
+	}
+
+	return route
+}
+
+/*
+knownRoutes: Returns every line that can be answered, in sorted order. These are the
+lines currently in the departure store plus the explicitly configured ones.
+*/
+func knownRoutes() (routes []string) {
+	seen := make(map[string]bool)
+
+	add := func(route string) {
+		if route != "" && route != ALLROUTES && !seen[strings.ToUpper(route)] {
+			seen[strings.ToUpper(route)] = true
+			routes = append(routes, route)
+		}
+	}
+
+	for _, route := range departureData.routes() {
+		add(route)
+	}
+
+	for _, route := range configRoutes {
+		add(route)
+	}
+
+	for _, stopRoutes := range configStopRoutes {
+		for _, route := range stopRoutes {
+			add(route)
+		}
+	}
+
+	sort.Strings(routes)
+	return
+}
+
+/*
+resolveRoute: Resolves a spoken route against the known lines.
+Only an exact match is taken as the route. Otherwise lines that start with the spoken
+identifier are candidates for the user to confirm or pick, e.g. "21" matches 214 and
+215, and "231" matches 231N when there is no 231.
+*/
+func resolveRoute(spoken string) (resolution routeResolution) {
+	resolution.spoken = normaliseRoute(spoken)
+	if resolution.spoken == "" {
+		return
+	}
+
+	known := knownRoutes()
+
+	for _, route := range known {
+		if strings.EqualFold(route, resolution.spoken) {
+			resolution.route = route
+			return
+		}
+	}
+
+	for _, route := range known {
+		if strings.HasPrefix(strings.ToUpper(route), resolution.spoken) {
+			resolution.candidates = append(resolution.candidates, route)
+		}
+	}
+
+	return
+}
+
+/*
+ambiguous: Checks if the user has to confirm a candidate line or pick one of several.
+*/
+func (resolution routeResolution) ambiguous() bool {
+	return resolution.route == "" && len(resolution.candidates) > 0
+}
+
+/*
+disambiguationPrompt: Asks the user, in the given language, which of the candidate
+lines was meant, e.g. "Did you mean 214 or 215?".
+*/
+func (resolution routeResolution) disambiguationPrompt(lang string) string {
+	return message(lang, msgRouteAmbiguous, orList(resolution.candidates, lang))
+}
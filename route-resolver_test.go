@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveRoute(t *testing.T) {
+	configRoutes = []string{"214", "215", "231N", "550"}
+	configStopRoutes = map[string][]string{"hsl:1": {"321", "*"}}
+	departureData = newDepartureStore([]string{"HSL:1"})
+	departureData.update("HSL:1", routeData{
+		stopDetails:   stopStruct{gtfsId: "HSL:1"},
+		arrDepDetails: []routeArrDepDetails{{route: "548"}, {route: "215"}},
+	})
+	defer func() { configRoutes, configStopRoutes, departureData = nil, nil, nil }()
+
+	tests := []struct {
+		name       string
+		spoken     string
+		route      string
+		candidates []string
+		ambiguous  bool
+	}{
+		{"exact", "215", "215", nil, false},
+		{"exact from stored departures", "548", "548", nil, false},
+		{"exact from stop routes", "321", "321", nil, false},
+		{"digits spoken apart", "2 1 4", "214", nil, false},
+		{"night bus", "night bus 231", "231N", nil, false},
+		{"line number", "line number 215", "215", nil, false},
+		{"no is not a filler word", "no 215", "", nil, false},
+		{"night suffix spoken apart", "231 n", "231N", nil, false},
+		{"lower case", "231n", "231N", nil, false},
+		{"several prefix matches", "21", "", []string{"214", "215"}, true},
+		{"single prefix match is confirmed", "231", "", []string{"231N"}, true},
+		{"single prefix match of a longer line", "55", "", []string{"550"}, true},
+		{"unknown", "999", "", nil, false},
+		{"wildcard is no route", "*", "", nil, false},
+		{"only filler words", "the bus", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolution := resolveRoute(tt.spoken)
+
+			if resolution.route != tt.route {
+				t.Errorf("route = %q, want %q", resolution.route, tt.route)
+			}
+			if !reflect.DeepEqual(resolution.candidates, tt.candidates) {
+				t.Errorf("candidates = %v, want %v", resolution.candidates, tt.candidates)
+			}
+			if resolution.ambiguous() != tt.ambiguous {
+				t.Errorf("ambiguous = %v, want %v", resolution.ambiguous(), tt.ambiguous)
+			}
+		})
+	}
+}
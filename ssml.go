@@ -0,0 +1,129 @@
+/*
+ssml.go
+
+Speech output rendered both as plain text and as SSML.
+- Times are read as times of day, stop codes letter by letter and stop names with
+  the pronunciation of their language.
+- Departures are separated by short pauses.
+- The plain text rendering is used for displays and as fallback for clients without SSML.
+*/
+
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Pause between listed departures
+const departurePause string = "300ms"
+
+// Escapes text for SSML
+var ssmlEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\"", "&quot;",
+	"'", "&apos;",
+)
+
+// Text to be spoken, in plain and SSML rendering. Values are immutable, every
+// method returns an extended copy.
+type spokenText struct {
+	text string
+	ssml string
+}
+
+/*
+plainSpeech: Helper function - Speech without any markup.
+*/
+func plainSpeech(text string) spokenText {
+	return spokenText{}.say(text)
+}
+
+/*
+say: Appends plain text.
+*/
+func (st spokenText) say(text string) spokenText {
+	st.text = st.text + text
+	st.ssml = st.ssml + ssmlEscaper.Replace(text)
+
+	return st
+}
+
+/*
+add: Appends other speech.
+*/
+func (st spokenText) add(other spokenText) spokenText {
+	st.text = st.text + other.text
+	st.ssml = st.ssml + other.ssml
+
+	return st
+}
+
+/*
+sayStopName: Appends the stop name for the reply language, pronounced in the language
+of the name, e.g. Finnish.
+*/
+func (st spokenText) sayStopName(stop stopStruct, lang string) spokenText {
+	name, pronunciation := localStopName(stop, lang)
+
+	st.text = st.text + name
+	st.ssml = st.ssml + `<lang xml:lang="` + pronunciation + `">` + ssmlEscaper.Replace(name) + `</lang>`
+
+	return st
+}
+
+/*
+sayStopCode: Appends a stop code, spelled out, e.g. "E 1 4 3 9".
+*/
+func (st spokenText) sayStopCode(code string) spokenText {
+	st.text = st.text + code
+	st.ssml = st.ssml + `<say-as interpret-as="characters">` + ssmlEscaper.Replace(code) + `</say-as>`
+
+	return st
+}
+
+/*
+sayClock: Appends a time of day in Helsinki, e.g. "15:04", written in the convention
+of the reply language.
+*/
+func (st spokenText) sayClock(t time.Time, lang string) spokenText {
+	clock := t.In(hslLocation).Format("15:04")
+
+	st.text = st.text + localClock(t, lang)
+	st.ssml = st.ssml + `<say-as interpret-as="time" format="hms24">` + clock + `</say-as>`
+
+	return st
+}
+
+/*
+pause: Appends a separator, e.g. ", ", followed by a short pause in speech.
+*/
+func (st spokenText) pause(separator string) spokenText {
+	st = st.say(separator)
+	st.ssml = st.ssml + `<break time="` + departurePause + `"/>`
+
+	return st
+}
+
+/*
+speak: Returns the complete SSML document.
+*/
+func (st spokenText) speak() string {
+	return "<speak>" + st.ssml + "</speak>"
+}
+
+/*
+joinSpeech: Helper function - Joins speech with pauses in between.
+*/
+func joinSpeech(speech []spokenText, separator string) (joined spokenText) {
+	for indx, st := range speech {
+		if indx > 0 {
+			joined = joined.pause(separator)
+		}
+		joined = joined.add(st)
+	}
+
+	return
+}
@@ -0,0 +1,245 @@
+/*
+stop-lookup.go
+
+Resolves stops given by stop code or name into gtfsIds, so the config file does not
+need hand-run GraphQL queries.
+- Stops can be given as gtfsId ("HSL:2143218"), stop code ("E1439"), name
+  ("Jupperinympyrä") or name and platform ("Kamppi/12") wherever the config expects
+  a gtfsId: "stopGtfsIds", the keys of "stopRoutes" and the stops of call signs.
+- Codes and names are searched with the Digitransit stops query. A code must match
+  exactly, a name must match a single stop, otherwise the candidates are logged.
+- Resolved stops are kept in stop-lookup.json with name, code and coordinates, so
+  they are resolved only once and survive HSL API outages. Only the webserver and
+  "stops resolve" write the file, "next" resolves for its answer only.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+isGtfsId: Helper function - Checks if a stop reference already is a gtfsId. Feed ids are
+separated by a colon, which stop codes and names do not have.
+*/
+func isGtfsId(ref string) bool {
+	return strings.Contains(ref, ":")
+}
+
+/*
+stopLookupKey: Helper function - Key of a stop reference in the lookup. Config keys are
+handed out in lower case, so references are case insensitive.
+*/
+func stopLookupKey(ref string) string {
+	return strings.ToLower(strings.TrimSpace(ref))
+}
+
+/*
+loadStopLookup: Reads the stops resolved earlier from the lookup file. A missing file
+is an empty lookup.
+*/
+func loadStopLookup(file string) (map[string]stopLookupEntry, error) {
+	lookup := make(map[string]stopLookupEntry)
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return lookup, nil
+	}
+	if err != nil {
+		return lookup, err
+	}
+
+	if err := json.Unmarshal(data, &lookup); err != nil {
+		return make(map[string]stopLookupEntry), fmt.Errorf("invalid %s: %v", file, err)
+	}
+
+	return lookup, nil
+}
+
+/*
+saveStopLookup: Writes the resolved stops to the lookup file. The file is replaced as a
+whole, so a failed write does not leave it half written.
+*/
+func saveStopLookup(file string, lookup map[string]stopLookupEntry) error {
+	data, err := json.MarshalIndent(lookup, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+/*
+resolveStopRef: Resolves a stop code, name or name/platform into a stop. Stops in the
+lookup are not searched again, cached tells if the stop came from there.
+*/
+func resolveStopRef(ref string, lookup map[string]stopLookupEntry) (entry stopLookupEntry, cached bool, err error) {
+	if entry, ok := lookup[stopLookupKey(ref)]; ok {
+		return entry, true, nil
+	}
+
+	name, platform := strings.TrimSpace(ref), ""
+	if indx := strings.LastIndex(name, "/"); indx >= 0 {
+		name, platform = strings.TrimSpace(name[:indx]), strings.TrimSpace(name[indx+1:])
+	}
+
+	stops, err := searchStops(name)
+	if err != nil {
+		return
+	}
+
+	stop, err := pickStop(name, platform, stops)
+	if err != nil {
+		return
+	}
+
+	entry = stopLookupEntry{
+		GtfsId:    stop.gtfsId,
+		Name:      stop.name,
+		Code:      stop.code,
+		Platform:  stop.platform,
+		Latitude:  stop.latitude,
+		Longitude: stop.longitude,
+	}
+
+	return
+}
+
+/*
+pickStop: Picks the stop meant by a code or name from the search results. A stop code
+matches a single stop. Stops sharing a name, e.g. the two sides of a street, are told
+apart by platform or have to be given by code.
+*/
+func pickStop(name string, platform string, stops []stopStruct) (stopStruct, error) {
+	if platform == "" {
+		for _, stop := range stops {
+			if strings.EqualFold(stop.code, name) {
+				return stop, nil
+			}
+		}
+	}
+
+	var matches []stopStruct
+	for _, stop := range stops {
+		if strings.EqualFold(stop.name, name) && (platform == "" || strings.EqualFold(stop.platform, platform)) {
+			matches = append(matches, stop)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		if platform != "" {
+			return stopStruct{}, fmt.Errorf("no stop named %q with platform %q", name, platform)
+		}
+		return stopStruct{}, fmt.Errorf("no stop with code or name %q", name)
+	case 1:
+		return matches[0], nil
+	}
+
+	var candidates []string
+	for _, stop := range matches {
+		candidate := stop.code + " (" + stop.gtfsId
+		if stop.platform != "" {
+			candidate = candidate + ", platform " + stop.platform
+		}
+		candidates = append(candidates, candidate+")")
+	}
+	sort.Strings(candidates)
+
+	return stopStruct{}, fmt.Errorf("%q matches several stops, give the stop code or name/platform of one: %s",
+		name, strings.Join(candidates, ", "))
+}
+
+/*
+resolveStops: Resolves stop references into gtfsIds, adding newly resolved stops to the
+lookup. Stops that cannot be resolved are left out. Changed tells if the lookup was
+extended.
+*/
+func resolveStops(refs []string, lookup map[string]stopLookupEntry) (gtfsIds []string, changed bool) {
+	for _, ref := range refs {
+		if isGtfsId(ref) {
+			gtfsIds = append(gtfsIds, ref)
+			continue
+		}
+
+		entry, cached, err := resolveStopRef(ref, lookup)
+		if err != nil {
+			log.Error("Stop ", ref, " could not be resolved - ", err)
+			continue
+		}
+
+		if !cached {
+			log.Info("Stop ", ref, " resolved to ", entry.GtfsId, " - ", entry.Name, " ", entry.Code)
+			lookup[stopLookupKey(ref)] = entry
+			changed = true
+		}
+
+		gtfsIds = append(gtfsIds, entry.GtfsId)
+	}
+
+	return
+}
+
+/*
+resolveConfiguredStops: Replaces stop codes and names in the configuration with gtfsIds.
+Newly resolved stops are kept in the lookup file if saveLookup is set.
+*/
+func resolveConfiguredStops(saveLookup bool) {
+	lookup, err := loadStopLookup(STOPLOOKUPFILE)
+	if err != nil {
+		// Stops are resolved again and the file rewritten
+		log.Error("Stop lookup could not be read - ", err)
+	}
+
+	var changed, c bool
+
+	configStopGtfsIds, changed = resolveStops(configStopGtfsIds, lookup)
+
+	stopRoutes := make(map[string][]string)
+	for ref, routes := range configStopRoutes {
+		var gtfsIds []string
+		gtfsIds, c = resolveStops([]string{ref}, lookup)
+		changed = changed || c
+
+		for _, gtfsId := range gtfsIds {
+			stopRoutes[strings.ToLower(gtfsId)] = routes
+		}
+	}
+	configStopRoutes = stopRoutes
+
+	for callSign, dest := range configSigns {
+		if len(dest.stops) == 0 {
+			continue
+		}
+
+		dest.stops, c = resolveStops(dest.stops, lookup)
+		changed = changed || c
+
+		// An empty destination would match every departure
+		if dest.empty() {
+			log.Error("Call sign ", callSign, " has no headsigns or resolved stops, ignoring it")
+			delete(configSigns, callSign)
+			continue
+		}
+		configSigns[callSign] = dest
+	}
+
+	if changed && saveLookup {
+		if err := saveStopLookup(STOPLOOKUPFILE, lookup); err != nil {
+			log.Error("Stop lookup could not be saved - ", err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPickStop(t *testing.T) {
+	stops := []stopStruct{
+		{gtfsId: "HSL:2143218", name: "Jupperinympyrä", code: "E1439"},
+		{gtfsId: "HSL:1040279", name: "Kamppi", code: "H1234", platform: "12"},
+		{gtfsId: "HSL:1040280", name: "Kamppi", code: "H1235", platform: "13"},
+		{gtfsId: "HSL:2222222", name: "E1439 Katu", code: "E2222"},
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		platform string
+		gtfsId   string
+		err      string
+	}{
+		{"code", "E1439", "", "HSL:2143218", ""},
+		{"code in lower case", "e1439", "", "HSL:2143218", ""},
+		{"single stop by name", "jupperinympyrä", "", "HSL:2143218", ""},
+		{"name and platform", "Kamppi", "13", "HSL:1040280", ""},
+		{"name shared by several stops", "Kamppi", "", "", "H1234 (HSL:1040279, platform 12), H1235 (HSL:1040280, platform 13)"},
+		{"unknown platform", "Kamppi", "99", "", `no stop named "Kamppi" with platform "99"`},
+		{"unknown", "Sello", "", "", `no stop with code or name "Sello"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop, err := pickStop(tt.ref, tt.platform, stops)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("pickStop() = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil || stop.gtfsId != tt.gtfsId {
+				t.Errorf("pickStop() = %q, %v, want %q", stop.gtfsId, err, tt.gtfsId)
+			}
+		})
+	}
+}
+
+func TestResolveStopsFromLookup(t *testing.T) {
+	lookup := map[string]stopLookupEntry{
+		"e1439":     {GtfsId: "HSL:2143218", Name: "Jupperinympyrä", Code: "E1439"},
+		"kamppi/12": {GtfsId: "HSL:1040279", Name: "Kamppi", Code: "H1234", Platform: "12"},
+	}
+
+	tests := []struct {
+		name string
+		refs []string
+		want []string
+	}{
+		{"gtfsIds are kept", []string{"HSL:2143202"}, []string{"HSL:2143202"}},
+		{"codes and names from the lookup", []string{"E1439", " Kamppi/12 "}, []string{"HSL:2143218", "HSL:1040279"}},
+		{"order is kept", []string{"Kamppi/12", "HSL:2143202", "e1439"}, []string{"HSL:1040279", "HSL:2143202", "HSL:2143218"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gtfsIds, changed := resolveStops(tt.refs, lookup)
+
+			if !reflect.DeepEqual(gtfsIds, tt.want) {
+				t.Errorf("resolveStops(%q) = %q, want %q", tt.refs, gtfsIds, tt.want)
+			}
+			if changed {
+				t.Error("lookup changed, want stops resolved from the lookup only")
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+/*
+telegram.go
+
+Telegram chat bot front-end.
+- Updates are long-polled from the Bot API, so no public endpoint is needed. The API
+  base URL is configurable, e.g. to test against a local fake API server.
+- Free text such as "215 sello", "sello" or "next" is parsed into the registered
+  intents and answered by the same logic as the voice assistants.
+- Departures are replied as a list per stop, other answers as their plain text.
+- The last query of every chat is kept for a while, for follow-up questions.
+- Only the chats in "telegramChats" are answered when it is configured, others are ignored.
+- The bot token is part of the Bot API URLs, so errors are logged without the URL.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Long polling timing
+const (
+	telegramPollTimeout  = 30 * time.Second
+	telegramRetryDelay   = 5 * time.Second
+	telegramFollowupTime = 10 * time.Minute
+)
+
+// Bot configuration, from the config file
+var telegramToken string
+var telegramApiUrl string
+var telegramAllowedChats map[int64]bool
+
+// Client for the Bot API. The timeout leaves room for the long poll.
+var telegramClient = &http.Client{Timeout: telegramPollTimeout + 10*time.Second}
+
+// Words of a chat message that carry no information about route or destination
+var chatFillerWords = map[string]bool{
+	"to":      true,
+	"when":    true,
+	"is":      true,
+	"and":     true,
+	"one":     true,
+	"that":    true,
+	"what":    true,
+	"about":   true,
+	"mihin":   true,
+	"milloin": true,
+	"när":     true,
+	"till":    true,
+}
+
+// Words asking for the departures after the ones told
+var chatFollowupWords = map[string]bool{
+	"next":      true,
+	"after":     true,
+	"more":      true,
+	"later":     true,
+	"seuraava":  true,
+	"seuraavat": true,
+	"lisää":     true,
+	"nästa":     true,
+	"fler":      true,
+}
+
+// Words confirming a suggestion, e.g. a yes to "Did you mean Sello?"
+var chatConfirmWords = map[string]bool{
+	"yes":   true,
+	"yeah":  true,
+	"kyllä": true,
+	"joo":   true,
+	"ja":    true,
+}
+
+// Last query and time of the last answer, per chat
+type chatFollowup struct {
+	query   routeQuery
+	updated time.Time
+}
+
+// Follow-up queries of the chats
+var telegramChats = struct {
+	lock  sync.Mutex
+	chats map[int64]chatFollowup
+}{chats: make(map[int64]chatFollowup)}
+
+/*
+startTelegramBot: Starts long polling the Bot API in a go routine, if a bot token is
+configured.
+*/
+func startTelegramBot() {
+	if telegramToken == "" {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		var offset int64
+		for {
+			updates, err := telegramUpdates(offset)
+			if err != nil {
+				log.Error("Telegram getUpdates failed - ", err)
+				time.Sleep(telegramRetryDelay)
+				continue
+			}
+
+			for _, update := range updates {
+				offset = update.UpdateId + 1
+				if update.Message != nil && update.Message.Text != "" {
+					handleTelegramMessage(*update.Message, time.Now())
+				}
+			}
+		}
+	}()
+}
+
+/*
+parseTelegramChats: Parses the chat ids of the allow-list. Ids of groups are negative.
+*/
+func parseTelegramChats(ids []string) (chats map[int64]bool, err error) {
+	chats = make(map[int64]bool)
+
+	for _, id := range ids {
+		chatId, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat id %q", id)
+		}
+		chats[chatId] = true
+	}
+
+	return
+}
+
+/*
+telegramChatAllowed: Checks if the chat may use the bot. Without an allow-list every
+chat may.
+*/
+func telegramChatAllowed(chatId int64) bool {
+	return len(telegramAllowedChats) == 0 || telegramAllowedChats[chatId]
+}
+
+/*
+redactTelegramError: Helper function - Drops the request URL, and so the bot token,
+from errors of the HTTP client.
+*/
+func redactTelegramError(method string, err error) error {
+	if urlErr, ok := err.(*neturl.Error); ok {
+		return fmt.Errorf("%s %s: %v", urlErr.Op, method, urlErr.Err)
+	}
+
+	return err
+}
+
+/*
+telegramMethodUrl: Helper function - URL of a Bot API method.
+*/
+func telegramMethodUrl(method string) string {
+	return strings.TrimSuffix(telegramApiUrl, "/") + "/bot" + telegramToken + "/" + method
+}
+
+/*
+telegramUpdates: Long polls the updates after the given offset. Updates before the
+offset are confirmed to the Bot API and not sent again.
+*/
+func telegramUpdates(offset int64) ([]tgUpdate, error) {
+	resp, err := telegramClient.Get(telegramMethodUrl("getUpdates") +
+		fmt.Sprintf("?offset=%d&timeout=%d", offset, int(telegramPollTimeout/time.Second)))
+	if err != nil {
+		return nil, redactTelegramError("getUpdates", err)
+	}
+	defer resp.Body.Close()
+
+	var updates tgUpdatesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&updates); err != nil {
+		return nil, fmt.Errorf("decoding updates failed: %v", err)
+	}
+
+	if !updates.Ok {
+		return nil, fmt.Errorf("%s: %s", resp.Status, updates.Description)
+	}
+
+	return updates.Result, nil
+}
+
+/*
+sendTelegramMessage: Sends an HTML formatted message to the chat.
+*/
+func sendTelegramMessage(chatId int64, text string) error {
+	body, err := json.Marshal(tgSendMessage{ChatId: chatId, Text: text, ParseMode: "HTML"})
+	if err != nil {
+		return err
+	}
+
+	resp, err := telegramClient.Post(telegramMethodUrl("sendMessage"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return redactTelegramError("sendMessage", err)
+	}
+	defer resp.Body.Close()
+
+	var sent tgSendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err != nil {
+		return fmt.Errorf("decoding response failed: %v", err)
+	}
+
+	if !sent.Ok {
+		return fmt.Errorf("%s: %s", resp.Status, sent.Description)
+	}
+
+	return nil
+}
+
+/*
+handleTelegramMessage: Answers a chat message and replies to the chat. Messages of
+chats not on the allow-list are ignored.
+*/
+func handleTelegramMessage(msg tgMessage, now time.Time) {
+	if !telegramChatAllowed(msg.Chat.Id) {
+		log.Info("Telegram message from chat not allowed - ", msg.Chat.Id)
+		return
+	}
+
+	lang := langEnglish
+	if msg.From != nil {
+		lang = replyLanguage(msg.From.LanguageCode)
+	}
+
+	log.Info("Telegram message from chat - ", msg.Chat.Id, " text - ", msg.Text)
+
+	var answer routeAnswer
+
+	if strings.HasPrefix(msg.Text, "/start") || strings.HasPrefix(msg.Text, "/help") {
+		answer = promptAnswer(lang, message(lang, msgWelcomeText), message(lang, msgWelcome))
+	} else if intent, params := parseChatMessage(msg.Text); intent != "" {
+		answer = dispatchIntent(intent, func(name string) (interface{}, bool) {
+			value, ok := params[name]
+			return value, ok
+		}, lang, chatPreviousQuery(msg.Chat.Id, now))
+	} else {
+		answer = fallbackAnswer(lang)
+	}
+
+	if answer.followup.destination != "" {
+		telegramChats.lock.Lock()
+		telegramChats.chats[msg.Chat.Id] = chatFollowup{query: answer.followup, updated: now}
+		telegramChats.lock.Unlock()
+	}
+
+	if err := sendTelegramMessage(msg.Chat.Id, telegramReply(answer)); err != nil {
+		log.Error("Telegram sendMessage to chat - ", msg.Chat.Id, " failed - ", err)
+	}
+}
+
+/*
+chatPreviousQuery: Returns the last query of the chat, an empty query if there is none
+or it is too old to follow up. Old queries of all chats are dropped on the way.
+*/
+func chatPreviousQuery(chatId int64, now time.Time) routeQuery {
+	telegramChats.lock.Lock()
+	defer telegramChats.lock.Unlock()
+
+	for id, chat := range telegramChats.chats {
+		if now.Sub(chat.updated) > telegramFollowupTime {
+			delete(telegramChats.chats, id)
+		}
+	}
+
+	return telegramChats.chats[chatId].query
+}
+
+/*
+parseChatMessage: Parses a free text message into an intent and its parameters.
+Words with digits make the route, e.g. "215" or "231N", other words the destination.
+A route without destination changes the route of the previous query, "next" alone
+asks for the departures after the ones told and "yes" alone confirms a suggestion.
+Returns an empty intent if nothing was asked.
+*/
+func parseChatMessage(text string) (intent string, params map[string]interface{}) {
+	var routeWords, destWords []string
+	followup, confirm := false, false
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("?!,.", r)
+	}) {
+		switch {
+		case routeFillerWords[word] || chatFillerWords[word]:
+			continue
+		case chatFollowupWords[word]:
+			followup = true
+		case chatConfirmWords[word]:
+			confirm = true
+		case routeNightWords[word] || strings.IndexFunc(word, unicode.IsDigit) >= 0:
+			routeWords = append(routeWords, word)
+		default:
+			destWords = append(destWords, word)
+		}
+	}
+
+	params = make(map[string]interface{})
+	if len(routeWords) > 0 {
+		params[routeParam.name] = strings.Join(routeWords, " ")
+	}
+	if len(destWords) > 0 {
+		params[destinationParam.name] = strings.Join(destWords, " ")
+	}
+
+	switch {
+	case len(routeWords) > 0 && len(destWords) > 0:
+		intent = BUSDEST
+	case len(routeWords) > 0:
+		intent = CHANGEROUTE
+	case len(destWords) > 0:
+		intent = DESTONLY
+	case followup:
+		intent = NEXTAFTER
+	case confirm:
+		intent = CONFIRM
+	}
+
+	return
+}
+
+/*
+telegramReply: Formats the answer into an HTML chat message. Departures are listed per
+stop, e.g. "215 Leppävaara 15:04 → 15:06 (+2 min)".
+*/
+func telegramReply(answer routeAnswer) string {
+	if answer.status != answerOK {
+		var lines []string
+		for _, st := range answer.speech {
+			lines = append(lines, html.EscapeString(st.text))
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	var stops []string
+	for _, stopDeps := range answer.departures {
+		name, _ := localStopName(stopDeps.stop, answer.language)
+		lines := []string{"<b>" + html.EscapeString(name) + "</b> (" + html.EscapeString(stopDeps.stop.code) + ")"}
+
+		for _, arrDep := range stopDeps.departures {
+			lines = append(lines, "<b>"+html.EscapeString(arrDep.route)+"</b> "+
+				html.EscapeString(departureSummary(arrDep, answer.language)))
+		}
+
+		stops = append(stops, strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(stops, "\n\n")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTelegramToken = "123456:secret-test-token"
+
+// fakeTelegramApi is a Bot API server handing out queued updates and recording the
+// messages sent.
+type fakeTelegramApi struct {
+	lock    sync.Mutex
+	updates []tgUpdate
+	sent    []tgSendMessage
+}
+
+func (api *fakeTelegramApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/bot" + testTelegramToken + "/getUpdates":
+		json.NewEncoder(w).Encode(tgUpdatesResponse{Ok: true, Result: api.updates})
+		api.updates = nil
+	case "/bot" + testTelegramToken + "/sendMessage":
+		var msg tgSendMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tgSendResponse{Description: "Bad Request: " + err.Error()})
+			return
+		}
+		api.sent = append(api.sent, msg)
+		json.NewEncoder(w).Encode(tgSendResponse{Ok: true})
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(tgSendResponse{Description: "Unauthorized"})
+	}
+}
+
+// takeSent returns the messages sent since the last call.
+func (api *fakeTelegramApi) takeSent() []tgSendMessage {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	sent := api.sent
+	api.sent = nil
+	return sent
+}
+
+func startFakeTelegramApi(t *testing.T) *fakeTelegramApi {
+	api := &fakeTelegramApi{}
+	server := httptest.NewServer(api)
+
+	telegramToken, telegramApiUrl = testTelegramToken, server.URL+"/"
+	t.Cleanup(func() {
+		server.Close()
+		telegramToken, telegramApiUrl, telegramAllowedChats = "", "", nil
+	})
+
+	return api
+}
+
+func TestTelegramBotAgainstFakeApi(t *testing.T) {
+	setupTestDepartures(t, time.Now())
+	api := startFakeTelegramApi(t)
+	telegramAllowedChats = map[int64]bool{1: true, 2: true}
+
+	update := func(updateId int64, chatId int64, lang string, text string) tgUpdate {
+		return tgUpdate{UpdateId: updateId, Message: &tgMessage{
+			MessageId: updateId,
+			From:      &tgUser{Id: chatId, LanguageCode: lang},
+			Chat:      tgChat{Id: chatId},
+			Text:      text,
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		update tgUpdate
+		reply  []string
+	}{
+		{"welcome", update(10, 1, "en", "/start"), []string{message(langEnglish, msgWelcome)}},
+		{"route and destination", update(11, 1, "en", "when is the next 215 to sello?"), []string{"<b>Jupperinympyrä</b> (E1439)", "<b>215</b>"}},
+		{"destination only", update(12, 2, "en", "sello"), []string{"<b>215</b>", "<b>214</b>"}},
+		{"next follows up the chat", update(13, 1, "en", "next"), []string{"<b>215</b>"}},
+		{"change route", update(14, 1, "en", "214"), []string{"<b>214</b>"}},
+		{"finnish", update(15, 2, "fi", "sello"), []string{"<b>215</b>"}},
+		{"unknown destination", update(16, 1, "en", "airport"), []string{message(langEnglish, msgDestUnmapped)}},
+		{"chat not allowed", update(17, 3, "en", "sello"), nil},
+	}
+
+	for _, tt := range tests {
+		api.lock.Lock()
+		api.updates = append(api.updates, tt.update)
+		api.lock.Unlock()
+	}
+
+	updates, err := telegramUpdates(0)
+	if err != nil {
+		t.Fatalf("telegramUpdates() = %v", err)
+	}
+	if len(updates) != len(tests) {
+		t.Fatalf("got %d updates, want %d", len(updates), len(tests))
+	}
+
+	for indx, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handleTelegramMessage(*updates[indx].Message, time.Now())
+			sent := api.takeSent()
+
+			if tt.reply == nil {
+				if len(sent) != 0 {
+					t.Errorf("replied %q, want no reply", sent[0].Text)
+				}
+				return
+			}
+
+			if len(sent) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(sent))
+			}
+			if sent[0].ChatId != tt.update.Message.Chat.Id || sent[0].ParseMode != "HTML" {
+				t.Errorf("sent to chat %d as %q, want chat %d as HTML", sent[0].ChatId, sent[0].ParseMode, tt.update.Message.Chat.Id)
+			}
+			for _, want := range tt.reply {
+				if !strings.Contains(sent[0].Text, want) {
+					t.Errorf("reply %q does not contain %q", sent[0].Text, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTelegramErrorsHideToken(t *testing.T) {
+	startFakeTelegramApi(t)
+
+	// Nothing listens on the discard port
+	telegramApiUrl = "http://127.0.0.1:9/"
+
+	_, err := telegramUpdates(0)
+	if err == nil || strings.Contains(err.Error(), testTelegramToken) {
+		t.Errorf("telegramUpdates() = %v, want an error without the token", err)
+	}
+
+	err = sendTelegramMessage(1, "hello")
+	if err == nil || strings.Contains(err.Error(), testTelegramToken) {
+		t.Errorf("sendTelegramMessage() = %v, want an error without the token", err)
+	}
+}
+
+func TestTelegramApiErrors(t *testing.T) {
+	startFakeTelegramApi(t)
+	telegramToken = "654321:wrong-token"
+
+	if _, err := telegramUpdates(0); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("telegramUpdates() = %v, want Unauthorized", err)
+	}
+
+	if err := sendTelegramMessage(1, "hello"); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("sendTelegramMessage() = %v, want Unauthorized", err)
+	}
+}
+
+func TestParseTelegramChats(t *testing.T) {
+	tests := []struct {
+		name  string
+		ids   []string
+		chats []int64
+		ok    bool
+	}{
+		{"none", nil, nil, true},
+		{"user and group", []string{"123456789", "-1001234567890"}, []int64{123456789, -1001234567890}, true},
+		{"not a number", []string{"@mychannel"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chats, err := parseTelegramChats(tt.ids)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseTelegramChats() = %v, want ok %v", err, tt.ok)
+			}
+
+			if len(chats) != len(tt.chats) {
+				t.Errorf("got %d chats, want %d", len(chats), len(tt.chats))
+			}
+			for _, id := range tt.chats {
+				if !chats[id] {
+					t.Errorf("chat %d missing", id)
+				}
+			}
+		})
+	}
+}
+
+func TestParseChatMessage(t *testing.T) {
+	tests := []struct {
+		text   string
+		intent string
+		route  string
+		dest   string
+	}{
+		{"sello", DESTONLY, "", "sello"},
+		{"bus to Sello?", DESTONLY, "", "sello"},
+		{"215 sello", BUSDEST, "215", "sello"},
+		{"When is the next 215 to Sello?", BUSDEST, "215", "sello"},
+		{"night bus 231 to sports hall", BUSDEST, "night 231", "sports hall"},
+		{"what about 214", CHANGEROUTE, "214", ""},
+		{"next", NEXTAFTER, "", ""},
+		{"seuraava?", NEXTAFTER, "", ""},
+		{"yes", CONFIRM, "", ""},
+		{"joo", CONFIRM, "", ""},
+		{"no 215", BUSDEST, "215", "no"},
+		{"bus number 215 sello", BUSDEST, "215", "sello"},
+		{"?!", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			intent, params := parseChatMessage(tt.text)
+
+			route, _ := params[routeParam.name].(string)
+			dest, _ := params[destinationParam.name].(string)
+			if intent != tt.intent || route != tt.route || dest != tt.dest {
+				t.Errorf("parseChatMessage(%q) = %q route %q dest %q, want %q route %q dest %q",
+					tt.text, intent, route, dest, tt.intent, tt.route, tt.dest)
+			}
+		})
+	}
+}
@@ -7,14 +7,25 @@ types.go
 
 package main
 
+import "time"
+
 const url string = "https://api.digitransit.fi/routing/v1/routers/hsl/index/graphql"
 
+// Stops resolved from codes or names are kept in this file, next to the config file
+const STOPLOOKUPFILE string = "stop-lookup.json"
+
 // Intent matching strings
 const (
   DESTONLY string = "Destination-Only"
   BUSDEST string = "Bus-Destination"
+  NEXTAFTER string = "Next-After"
+  CHANGEROUTE string = "Change-Route"
+  CONFIRM string = "Confirm"
 )
 
+// Context carrying the last query of a conversation, for follow-up questions
+const FOLLOWUPCONTEXT string = "route-followup"
+
 // Configuration keys
 const (
 	ROUTES      string = "routes"
@@ -25,6 +36,40 @@ const (
   SERVERCERT  string = "serverCert"
   SERVERKEY   string = "serverKey"
   STOPGTFSIDS string = "stopGtfsIds"
+  REFRESHINTERVAL string = "refreshInterval"
+  RUSHINTERVAL    string = "rushHourRefreshInterval"
+  RUSHHOURS       string = "rushHours"
+  REFRESHJITTER   string = "refreshJitter"
+  CACHETTL        string = "cacheTTL"
+  STOPROUTES      string = "stopRoutes"
+  SIGNALIASES     string = "headsignAliases"
+  DEPARTUREGRACE  string = "departureGrace"
+  RELATIVEWINDOW  string = "relativeTimeWindow"
+  ALEXASKILLID    string = "alexaSkillId"
+  TELEGRAMTOKEN   string = "telegramToken"
+  TELEGRAMAPIURL  string = "telegramApiUrl"
+  TELEGRAMCHATS   string = "telegramChats"
+  HOMELATITUDE    string = "homeLatitude"
+  HOMELONGITUDE   string = "homeLongitude"
+  HOMERADIUS      string = "homeRadius"
+  HOMEMODES       string = "homeModes"
+  NEARBYINTERVAL  string = "stopDiscoveryInterval"
+)
+
+// Route allow-list wildcard
+const ALLROUTES string = "*"
+
+// Defaults for optional parameters when not overridden in the config file
+const (
+  DEFAULTREFRESHINTERVAL string = "10m"
+  DEFAULTRUSHINTERVAL    string = "2m"
+  DEFAULTREFRESHJITTER   string = "20s"
+  DEFAULTCACHETTL        string = "1m"
+  DEFAULTDEPARTUREGRACE  string = "0s"
+  DEFAULTRELATIVEWINDOW  string = "10m"
+  DEFAULTTELEGRAMAPIURL  string = "https://api.telegram.org"
+  DEFAULTHOMERADIUS      int    = 500
+  DEFAULTNEARBYINTERVAL  string = "24h"
 )
 
 // A bus's arrival/departure details.
@@ -39,12 +84,28 @@ type routeArrDepDetails struct {
 	realtimeState      string
 	headSign           string
 	route              string
+	routeGtfsId        string
+	mode               string
+	patternCode        string
+	directionId        int
+	downstreamStops    []string
+
+	// Absolute departure instants in Europe/Helsinki, derived from serviceDay
+	scheduledDepartureTime time.Time
+	realtimeDepartureTime  time.Time
+}
+
+// A rush hour window, in minutes since midnight. [start, end)
+type rushHourWindow struct {
+	start int
+	end   int
 }
 
-// A bus's headsigns source/destination
-type routeHeadSigns struct {
-	routeName string
+// Where a call sign takes the user: buses showing any of the headsigns, or buses
+// that stop at any of the stops later on their way.
+type destinationSpec struct {
 	headsigns []string
+	stops     []string
 }
 
 // Main structure that holds all stops and buses from the stop.
@@ -57,19 +118,181 @@ type routeData struct {
 type stopStruct struct {
 	gtfsId    string
 	name      string
+	nameSv    string
 	code      string
+	platform  string
 	latitude  float64
 	longitude float64
 }
 
+// Stop found near the home coordinate, with its distance in meters and the lines serving it
+type nearbyStop struct {
+	stop     stopStruct
+	distance int
+	routes   []string
+	modes    []string
+}
+
+// Stop resolved from a stop code or name, as kept in the stop lookup file.
+type stopLookupEntry struct {
+	GtfsId    string  `json:"gtfsId"`
+	Name      string  `json:"name"`
+	Code      string  `json:"code"`
+	Platform  string  `json:"platform,omitempty"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+}
+
+// GraphQL response from HSL API for a stop query.
+type gqlStopResponse struct {
+	Stop *gqlStop `json:"stop"`
+}
+
+// GraphQL response of the stops by radius query.
+type gqlStopsByRadiusResponse struct {
+	StopsByRadius gqlStopAtDistanceConnection `json:"stopsByRadius"`
+}
+
+// GraphQL connection of stops at a distance.
+type gqlStopAtDistanceConnection struct {
+	Edges    []gqlStopAtDistanceEdge `json:"edges"`
+	PageInfo gqlPageInfo             `json:"pageInfo"`
+}
+
+// GraphQL page info of a connection, for fetching the next page.
+type gqlPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+// GraphQL edge of the stops at a distance connection.
+type gqlStopAtDistanceEdge struct {
+	Node gqlStopAtDistance `json:"node"`
+}
+
+// GraphQL stop with its walking distance in meters.
+type gqlStopAtDistance struct {
+	Stop     gqlStop `json:"stop"`
+	Distance int     `json:"distance"`
+}
+
+// GraphQL response of the stops search query.
+type gqlStopsResponse struct {
+	Stops []gqlStop `json:"stops"`
+}
+
+// GraphQL stop as returned by HSL API.
+type gqlStop struct {
+	GtfsId                   string        `json:"gtfsId"`
+	Name                     string        `json:"name"`
+	NameSv                   string        `json:"nameSv"`
+	Code                     string        `json:"code"`
+	PlatformCode             string        `json:"platformCode"`
+	Routes                   []gqlRoute    `json:"routes"`
+	Lat                      float64       `json:"lat"`
+	Lon                      float64       `json:"lon"`
+	StoptimesWithoutPatterns []gqlStoptime `json:"stoptimesWithoutPatterns"`
+}
+
+// GraphQL trip of a stoptime.
+type gqlTrip struct {
+	Route   gqlRoute   `json:"route"`
+	Pattern gqlPattern `json:"pattern"`
+}
+
+// GraphQL route of a trip.
+type gqlRoute struct {
+	ShortName string `json:"shortName"`
+	GtfsId    string `json:"gtfsId"`
+	Mode      string `json:"mode"`
+}
+
+// GraphQL pattern of a trip. Direction is 0 or 1 for the two directions of a route.
+// Stops are in the order the trip visits them.
+type gqlPattern struct {
+	Code        string           `json:"code"`
+	DirectionId int              `json:"directionId"`
+	Stops       []gqlPatternStop `json:"stops"`
+}
+
+// GraphQL stop on a pattern.
+type gqlPatternStop struct {
+	GtfsId string `json:"gtfsId"`
+}
+
+// GraphQL arrival/departure of a trip at a stop. Times are seconds since serviceDay.
+type gqlStoptime struct {
+	ScheduledArrival   float64  `json:"scheduledArrival"`
+	RealtimeArrival    float64  `json:"realtimeArrival"`
+	ArrivalDelay       float64  `json:"arrivalDelay"`
+	ScheduledDeparture float64  `json:"scheduledDeparture"`
+	RealtimeDeparture  float64  `json:"realtimeDeparture"`
+	DepartureDelay     float64  `json:"departureDelay"`
+	Realtime           bool     `json:"realtime"`
+	RealtimeState      string   `json:"realtimeState"`
+	ServiceDay         int64    `json:"serviceDay"`
+	Headsign           string   `json:"headsign"`
+	Trip               *gqlTrip `json:"trip"`
+}
+
 // Structure for Webhook Response to Dialogflow.
+// One of TextToSpeech and Ssml is set.
 type simpleRespStruct struct {
-	TextToSpeech string `json:"textToSpeech"`
+	TextToSpeech string `json:"textToSpeech,omitempty"`
+	Ssml         string `json:"ssml,omitempty"`
+	DisplayText  string `json:"displayText,omitempty"`
 }
 
-// Structure for Webhook Response to Dialogflow.
+// Structure for Webhook Response to Dialogflow. Exactly one of the fields is set.
 type itemStruct struct {
-	SimpleResponse simpleRespStruct `json:"simpleResponse"`
+	SimpleResponse *simpleRespStruct `json:"simpleResponse,omitempty"`
+	BasicCard      *basicCardStruct  `json:"basicCard,omitempty"`
+	TableCard      *tableCardStruct  `json:"tableCard,omitempty"`
+}
+
+// Structure for Webhook Response to Dialogflow.
+type basicCardStruct struct {
+	Title         string         `json:"title"`
+	Subtitle      string         `json:"subtitle,omitempty"`
+	FormattedText string         `json:"formattedText,omitempty"`
+	Buttons       []buttonStruct `json:"buttons,omitempty"`
+}
+
+// Structure for Webhook Response to Dialogflow.
+type tableCardStruct struct {
+	Title            string                   `json:"title"`
+	Subtitle         string                   `json:"subtitle,omitempty"`
+	ColumnProperties []columnPropertiesStruct `json:"columnProperties"`
+	Rows             []tableRowStruct         `json:"rows"`
+	Buttons          []buttonStruct           `json:"buttons,omitempty"`
+}
+
+// Structure for Webhook Response to Dialogflow.
+type columnPropertiesStruct struct {
+	Header              string `json:"header"`
+	HorizontalAlignment string `json:"horizontalAlignment,omitempty"`
+}
+
+// Structure for Webhook Response to Dialogflow.
+type tableRowStruct struct {
+	Cells        []tableCellStruct `json:"cells"`
+	DividerAfter bool              `json:"dividerAfter,omitempty"`
+}
+
+// Structure for Webhook Response to Dialogflow.
+type tableCellStruct struct {
+	Text string `json:"text"`
+}
+
+// Structure for Webhook Response to Dialogflow.
+type buttonStruct struct {
+	Title         string              `json:"title"`
+	OpenUrlAction openUrlActionStruct `json:"openUrlAction"`
+}
+
+// Structure for Webhook Response to Dialogflow.
+type openUrlActionStruct struct {
+	Url string `json:"url"`
 }
 
 // Structure for Webhook Response to Dialogflow.
@@ -80,7 +303,7 @@ type suggestionStruct struct {
 // Structure for Webhook Response to Dialogflow.
 type richResponseStruct struct {
 	Items       []itemStruct       `json:"items"`
-	Suggestions []suggestionStruct `json:"suggestions"`
+	Suggestions []suggestionStruct `json:"suggestions,omitempty"`
 }
 
 // Structure for Webhook Response to Dialogflow.
@@ -94,8 +317,439 @@ type payloadStruct struct {
 	Google             googleStruct       `json:"google"`
 }
 
-// Structure for Webhook Response to Dialogflow.
-type gaWebHookResponse struct {
-	FulfillmentText  string        `json:"fulfillmentText"`
-	Payload          payloadStruct `json:"payload"`
-}
\ No newline at end of file
+// Dialogflow ES v2 WebhookRequest.
+// https://cloud.google.com/dialogflow/es/docs/reference/rpc/google.cloud.dialogflow.v2#webhookrequest
+type dfWebhookRequest struct {
+	ResponseId                  string                        `json:"responseId"`
+	Session                     string                        `json:"session"`
+	QueryResult                 dfQueryResult                 `json:"queryResult"`
+	OriginalDetectIntentRequest dfOriginalDetectIntentRequest `json:"originalDetectIntentRequest"`
+}
+
+// Dialogflow ES v2 QueryResult, the result of matching the user query to an intent.
+type dfQueryResult struct {
+	QueryText                   string                 `json:"queryText"`
+	LanguageCode                string                 `json:"languageCode"`
+	SpeechRecognitionConfidence float64                `json:"speechRecognitionConfidence"`
+	Action                      string                 `json:"action"`
+	Parameters                  map[string]interface{} `json:"parameters"`
+	AllRequiredParamsPresent    bool                   `json:"allRequiredParamsPresent"`
+	FulfillmentText             string                 `json:"fulfillmentText"`
+	FulfillmentMessages         []dfMessage            `json:"fulfillmentMessages"`
+	WebhookSource               string                 `json:"webhookSource"`
+	WebhookPayload              map[string]interface{} `json:"webhookPayload"`
+	OutputContexts              []dfContext            `json:"outputContexts"`
+	Intent                      dfIntent               `json:"intent"`
+	IntentDetectionConfidence   float64                `json:"intentDetectionConfidence"`
+	DiagnosticInfo              map[string]interface{} `json:"diagnosticInfo"`
+}
+
+// Dialogflow ES v2 Intent, only the fields sent to webhooks.
+type dfIntent struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// Dialogflow ES v2 OriginalDetectIntentRequest, e.g. the Actions on Google request.
+type dfOriginalDetectIntentRequest struct {
+	Source  string                 `json:"source"`
+	Version string                 `json:"version"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// Dialogflow ES v2 Context. Name is "<session>/contexts/<context id>".
+type dfContext struct {
+	Name          string                 `json:"name"`
+	LifespanCount int                    `json:"lifespanCount,omitempty"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Dialogflow ES v2 EventInput, to trigger a follow up intent.
+type dfEventInput struct {
+	Name         string                 `json:"name"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	LanguageCode string                 `json:"languageCode,omitempty"`
+}
+
+// Dialogflow ES v2 SessionEntityType, to override entities for the session.
+type dfSessionEntityType struct {
+	Name               string     `json:"name"`
+	EntityOverrideMode string     `json:"entityOverrideMode"`
+	Entities           []dfEntity `json:"entities"`
+}
+
+// Dialogflow ES v2 entity of a SessionEntityType.
+type dfEntity struct {
+	Value    string   `json:"value"`
+	Synonyms []string `json:"synonyms"`
+}
+
+// Dialogflow ES v2 WebhookResponse.
+// https://cloud.google.com/dialogflow/es/docs/reference/rpc/google.cloud.dialogflow.v2#webhookresponse
+type dfWebhookResponse struct {
+	FulfillmentText     string                `json:"fulfillmentText,omitempty"`
+	FulfillmentMessages []dfMessage           `json:"fulfillmentMessages,omitempty"`
+	Source              string                `json:"source,omitempty"`
+	Payload             *payloadStruct        `json:"payload,omitempty"`
+	OutputContexts      []dfContext           `json:"outputContexts,omitempty"`
+	FollowupEventInput  *dfEventInput         `json:"followupEventInput,omitempty"`
+	SessionEntityTypes  []dfSessionEntityType `json:"sessionEntityTypes,omitempty"`
+}
+
+// Dialogflow ES v2 Intent.Message. Exactly one of the message fields is set. The Actions
+// on Google cards reuse the structures of the rich response payload.
+type dfMessage struct {
+	Platform          string                 `json:"platform,omitempty"`
+	Text              *dfText                `json:"text,omitempty"`
+	Image             *dfImage               `json:"image,omitempty"`
+	QuickReplies      *dfQuickReplies        `json:"quickReplies,omitempty"`
+	Card              *dfCard                `json:"card,omitempty"`
+	Payload           map[string]interface{} `json:"payload,omitempty"`
+	SimpleResponses   *dfSimpleResponses     `json:"simpleResponses,omitempty"`
+	BasicCard         *basicCardStruct       `json:"basicCard,omitempty"`
+	Suggestions       *dfSuggestions         `json:"suggestions,omitempty"`
+	LinkOutSuggestion *dfLinkOutSuggestion   `json:"linkOutSuggestion,omitempty"`
+	ListSelect        *dfListSelect          `json:"listSelect,omitempty"`
+	CarouselSelect    *dfCarouselSelect      `json:"carouselSelect,omitempty"`
+	TableCard         *tableCardStruct       `json:"tableCard,omitempty"`
+}
+
+// Dialogflow ES v2 text message.
+type dfText struct {
+	Text []string `json:"text"`
+}
+
+// Dialogflow ES v2 image message.
+type dfImage struct {
+	ImageUri          string `json:"imageUri"`
+	AccessibilityText string `json:"accessibilityText,omitempty"`
+}
+
+// Dialogflow ES v2 quick replies message.
+type dfQuickReplies struct {
+	Title        string   `json:"title,omitempty"`
+	QuickReplies []string `json:"quickReplies"`
+}
+
+// Dialogflow ES v2 card message.
+type dfCard struct {
+	Title    string         `json:"title,omitempty"`
+	Subtitle string         `json:"subtitle,omitempty"`
+	ImageUri string         `json:"imageUri,omitempty"`
+	Buttons  []dfCardButton `json:"buttons,omitempty"`
+}
+
+// Dialogflow ES v2 card button.
+type dfCardButton struct {
+	Text     string `json:"text"`
+	Postback string `json:"postback,omitempty"`
+}
+
+// Dialogflow ES v2 simple responses message, for Actions on Google.
+type dfSimpleResponses struct {
+	SimpleResponses []simpleRespStruct `json:"simpleResponses"`
+}
+
+// Dialogflow ES v2 suggestion chips message, for Actions on Google.
+type dfSuggestions struct {
+	Suggestions []suggestionStruct `json:"suggestions"`
+}
+
+// Dialogflow ES v2 link out suggestion message, for Actions on Google.
+type dfLinkOutSuggestion struct {
+	DestinationName string `json:"destinationName"`
+	Uri             string `json:"uri"`
+}
+
+// Dialogflow ES v2 list select message, for Actions on Google.
+type dfListSelect struct {
+	Title    string         `json:"title,omitempty"`
+	Items    []dfSelectItem `json:"items"`
+	Subtitle string         `json:"subtitle,omitempty"`
+}
+
+// Dialogflow ES v2 carousel select message, for Actions on Google.
+type dfCarouselSelect struct {
+	Items []dfSelectItem `json:"items"`
+}
+
+// Dialogflow ES v2 item of a list or carousel select.
+type dfSelectItem struct {
+	Info        dfSelectItemInfo `json:"info"`
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	Image       *dfImage         `json:"image,omitempty"`
+}
+
+// Dialogflow ES v2 key and synonyms the user can select an item with.
+type dfSelectItemInfo struct {
+	Key      string   `json:"key"`
+	Synonyms []string `json:"synonyms,omitempty"`
+}
+
+// Dialogflow CX WebhookRequest.
+// https://cloud.google.com/dialogflow/cx/docs/reference/rpc/google.cloud.dialogflow.cx.v3#webhookrequest
+type cxWebhookRequest struct {
+	DetectIntentResponseId string                 `json:"detectIntentResponseId"`
+	IntentInfo             *cxIntentInfo          `json:"intentInfo,omitempty"`
+	PageInfo               *cxPageInfo            `json:"pageInfo,omitempty"`
+	SessionInfo            cxSessionInfo          `json:"sessionInfo"`
+	FulfillmentInfo        cxFulfillmentInfo      `json:"fulfillmentInfo"`
+	Messages               []cxResponseMessage    `json:"messages,omitempty"`
+	Payload                map[string]interface{} `json:"payload,omitempty"`
+	Text                   string                 `json:"text,omitempty"`
+	Transcript             string                 `json:"transcript,omitempty"`
+	LanguageCode           string                 `json:"languageCode"`
+}
+
+// Dialogflow CX intent that was matched for the request.
+type cxIntentInfo struct {
+	LastMatchedIntent string                            `json:"lastMatchedIntent"`
+	DisplayName       string                            `json:"displayName"`
+	Parameters        map[string]cxIntentParameterValue `json:"parameters,omitempty"`
+	Confidence        float64                           `json:"confidence"`
+}
+
+// Dialogflow CX value of an intent parameter.
+type cxIntentParameterValue struct {
+	OriginalValue string      `json:"originalValue"`
+	ResolvedValue interface{} `json:"resolvedValue"`
+}
+
+// Dialogflow CX page of the session.
+type cxPageInfo struct {
+	CurrentPage string `json:"currentPage"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// Dialogflow CX session, its parameters live for the whole conversation.
+type cxSessionInfo struct {
+	Session    string                 `json:"session,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Dialogflow CX fulfillment that called the webhook. The tag identifies what to do.
+type cxFulfillmentInfo struct {
+	Tag string `json:"tag"`
+}
+
+// Dialogflow CX WebhookResponse.
+// https://cloud.google.com/dialogflow/cx/docs/reference/rpc/google.cloud.dialogflow.cx.v3#webhookresponse
+type cxWebhookResponse struct {
+	FulfillmentResponse *cxFulfillmentResponse `json:"fulfillmentResponse,omitempty"`
+	PageInfo            *cxPageInfo            `json:"pageInfo,omitempty"`
+	SessionInfo         *cxSessionInfo         `json:"sessionInfo,omitempty"`
+	Payload             map[string]interface{} `json:"payload,omitempty"`
+}
+
+// Dialogflow CX messages to reply with.
+type cxFulfillmentResponse struct {
+	Messages      []cxResponseMessage `json:"messages"`
+	MergeBehavior string              `json:"mergeBehavior,omitempty"`
+}
+
+// Dialogflow CX ResponseMessage. Exactly one of the message fields is set.
+type cxResponseMessage struct {
+	Text            *cxText                `json:"text,omitempty"`
+	OutputAudioText *cxOutputAudioText     `json:"outputAudioText,omitempty"`
+	Payload         map[string]interface{} `json:"payload,omitempty"`
+}
+
+// Dialogflow CX text message.
+type cxText struct {
+	Text []string `json:"text"`
+}
+
+// Dialogflow CX message for speech output. One of Text and Ssml is set.
+type cxOutputAudioText struct {
+	Text string `json:"text,omitempty"`
+	Ssml string `json:"ssml,omitempty"`
+}
+
+// Alexa Skills Kit request envelope. Only the fields used by the skill are modelled.
+type alexaRequestEnvelope struct {
+	Version string        `json:"version"`
+	Session *alexaSession `json:"session,omitempty"`
+	Context *alexaContext `json:"context,omitempty"`
+	Request alexaRequest  `json:"request"`
+}
+
+// Alexa session. Attributes are the session attributes returned in the previous response.
+type alexaSession struct {
+	New         bool                   `json:"new"`
+	SessionId   string                 `json:"sessionId"`
+	Application alexaApplication       `json:"application"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Alexa skill the request is for.
+type alexaApplication struct {
+	ApplicationId string `json:"applicationId"`
+}
+
+// Alexa context, the skill is identified here also for requests without a session.
+type alexaContext struct {
+	System alexaSystem `json:"System"`
+}
+
+// Alexa system state.
+type alexaSystem struct {
+	Application alexaApplication `json:"application"`
+}
+
+// Alexa request: LaunchRequest, IntentRequest or SessionEndedRequest.
+type alexaRequest struct {
+	Type      string       `json:"type"`
+	RequestId string       `json:"requestId"`
+	Timestamp string       `json:"timestamp"`
+	Locale    string       `json:"locale"`
+	Intent    *alexaIntent `json:"intent,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+}
+
+// Alexa intent with its slots, keyed by slot name.
+type alexaIntent struct {
+	Name  string               `json:"name"`
+	Slots map[string]alexaSlot `json:"slots,omitempty"`
+}
+
+// Alexa slot. Value is missing if the user did not fill the slot.
+type alexaSlot struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// Alexa response envelope.
+type alexaResponseEnvelope struct {
+	Version           string                 `json:"version"`
+	SessionAttributes map[string]interface{} `json:"sessionAttributes,omitempty"`
+	Response          alexaResponse          `json:"response"`
+}
+
+// Alexa response. ShouldEndSession is left out to keep the session open without
+// listening, false to listen for an answer.
+type alexaResponse struct {
+	OutputSpeech     *alexaOutputSpeech `json:"outputSpeech,omitempty"`
+	Card             *alexaCard         `json:"card,omitempty"`
+	Reprompt         *alexaReprompt     `json:"reprompt,omitempty"`
+	ShouldEndSession *bool              `json:"shouldEndSession,omitempty"`
+}
+
+// Alexa speech output, type "PlainText" or "SSML".
+type alexaOutputSpeech struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	Ssml string `json:"ssml,omitempty"`
+}
+
+// Alexa card shown in the Alexa app and on devices with a screen, type "Simple".
+type alexaCard struct {
+	Type    string `json:"type"`
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// Alexa reprompt, spoken if the user does not answer.
+type alexaReprompt struct {
+	OutputSpeech alexaOutputSpeech `json:"outputSpeech"`
+}
+
+// Telegram Bot API response of getUpdates.
+type tgUpdatesResponse struct {
+	Ok          bool       `json:"ok"`
+	Description string     `json:"description,omitempty"`
+	Result      []tgUpdate `json:"result"`
+}
+
+// Telegram Bot API response of sendMessage, the sent message is not used.
+type tgSendResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description,omitempty"`
+}
+
+// Telegram update. Only new messages are handled.
+type tgUpdate struct {
+	UpdateId int64      `json:"update_id"`
+	Message  *tgMessage `json:"message,omitempty"`
+}
+
+// Telegram message. Text is empty for messages without text, e.g. stickers.
+type tgMessage struct {
+	MessageId int64   `json:"message_id"`
+	From      *tgUser `json:"from,omitempty"`
+	Chat      tgChat  `json:"chat"`
+	Text      string  `json:"text,omitempty"`
+}
+
+// Telegram user. LanguageCode is the language of the user's client, e.g. "fi".
+type tgUser struct {
+	Id           int64  `json:"id"`
+	LanguageCode string `json:"language_code,omitempty"`
+}
+
+// Telegram chat.
+type tgChat struct {
+	Id int64 `json:"id"`
+}
+
+// Telegram sendMessage request.
+type tgSendMessage struct {
+	ChatId    int64  `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// REST API departure. Times are absolute RFC 3339 timestamps, departure is the realtime
+// departure when known and the scheduled one otherwise.
+type apiDeparture struct {
+	Stop               apiStop    `json:"stop"`
+	Route              string     `json:"route"`
+	RouteGtfsId        string     `json:"routeGtfsId"`
+	Mode               string     `json:"mode"`
+	Headsign           string     `json:"headsign"`
+	ScheduledDeparture time.Time  `json:"scheduledDeparture"`
+	RealtimeDeparture  *time.Time `json:"realtimeDeparture,omitempty"`
+	Departure          time.Time  `json:"departure"`
+	DelaySeconds       int        `json:"delaySeconds"`
+	Realtime           bool       `json:"realtime"`
+}
+
+// REST API stop. Updated is the time departures of the stop were last retrieved, missing
+// if they have not been yet.
+type apiStop struct {
+	GtfsId    string     `json:"gtfsId"`
+	Name      string     `json:"name,omitempty"`
+	NameSv    string     `json:"nameSv,omitempty"`
+	Code      string     `json:"code,omitempty"`
+	Latitude  float64    `json:"lat,omitempty"`
+	Longitude float64    `json:"lon,omitempty"`
+	Updated   *time.Time `json:"updated,omitempty"`
+}
+
+// REST API destination, a configured call sign.
+type apiDestination struct {
+	CallSign  string   `json:"callSign"`
+	Headsigns []string `json:"headsigns,omitempty"`
+	Stops     []string `json:"stops,omitempty"`
+}
+
+// REST API response of /api/v1/departures.
+type apiDeparturesResponse struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Departures  []apiDeparture `json:"departures"`
+}
+
+// REST API response of /api/v1/stops.
+type apiStopsResponse struct {
+	Stops []apiStop `json:"stops"`
+}
+
+// REST API response of /api/v1/destinations.
+type apiDestinationsResponse struct {
+	Destinations []apiDestination `json:"destinations"`
+}
+
+// REST API error response.
+type apiError struct {
+	Error string `json:"error"`
+}